@@ -0,0 +1,72 @@
+package format
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	for name, test := range map[string]struct {
+		a, b     string
+		expected string
+	}{
+		"identical": {
+			a:        "bpm 120\n+1\n",
+			b:        "bpm 120\n+1\n",
+			expected: "",
+		},
+		"insertion": {
+			a: "a\nb\nc\nd\ne\nf\n",
+			b: "a\nb\nc\nd\nx\ny\ne\nf\n",
+			expected: "@@ -2,5 +2,7 @@\n" +
+				" b\n c\n d\n+x\n+y\n e\n f\n",
+		},
+		"insertion into an empty file": {
+			a:        "",
+			b:        "a\nb\n",
+			expected: "@@ -0,0 +1,2 @@\n+a\n+b\n",
+		},
+		"deletion": {
+			a:        "a\nb\nc\nd\n",
+			b:        "a\nd\n",
+			expected: "@@ -1,4 +1,2 @@\n a\n-b\n-c\n d\n",
+		},
+		"deletion of everything": {
+			a:        "a\nb\n",
+			b:        "",
+			expected: "@@ -1,2 +0,0 @@\n-a\n-b\n",
+		},
+		"change in context": {
+			a: "1\n2\n3\n4\n5\n6\n7\n8\n9\n",
+			b: "1\n2\n3\n4\nfive\n6\n7\n8\n9\n",
+			expected: "@@ -2,7 +2,7 @@\n" +
+				" 2\n 3\n 4\n-5\n+five\n 6\n 7\n 8\n",
+		},
+		"close changes merge": {
+			a: "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n",
+			b: "one\n2\n3\n4\n5\n6\n7\nseven\n9\n10\n",
+			expected: "@@ -1,10 +1,10 @@\n" +
+				"-1\n+one\n 2\n 3\n 4\n 5\n 6\n 7\n-8\n+seven\n 9\n 10\n",
+		},
+		"distant changes split": {
+			a: "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n",
+			b: "one\n2\n3\n4\n5\n6\n7\n8\n9\nten\n",
+			expected: "@@ -1,4 +1,4 @@\n-1\n+one\n 2\n 3\n 4\n" +
+				"@@ -7,4 +7,4 @@\n 7\n 8\n 9\n-10\n+ten\n",
+		},
+		"missing newline": {
+			a:        "a\nb",
+			b:        "a\nb\n",
+			expected: "@@ -1,2 +1,2 @@\n a\n-b\n\\ No newline at end of file\n+b\n",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			header := "--- f.auto.txt\n+++ f.auto.txt (formatted)\n"
+			if test.expected != "" {
+				test.expected = header + test.expected
+			}
+			require.Equal(t, test.expected, unifiedDiff("f.auto.txt", test.a, test.b))
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package format
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/fruity-loozrz/go-scratchpad/internal/automation"
+	"github.com/spf13/cobra"
+)
+
+var check bool
+
+var errNotFormatted = errors.New("some files are not formatted")
+
+func NewFormatCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "fmt [automation files]",
+		Short: "Format automation files",
+		Long:  `Rewrite automation files in place in their canonical form, or with --check print the changes that would be made.`,
+		Args:  cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := runFormat(args, check); err != nil {
+				log.Fatal(err)
+			}
+		},
+	}
+
+	cmd.Flags().BoolVar(&check, "check", false, "print a diff instead of rewriting files and fail if any file is not formatted")
+
+	return cmd
+}
+
+func runFormat(fileNames []string, check bool) error {
+	notFormatted := false
+	for _, fileName := range fileNames {
+		changed, err := formatFile(fileName, check)
+		if err != nil {
+			return err
+		}
+		notFormatted = notFormatted || changed
+	}
+
+	if check && notFormatted {
+		return errNotFormatted
+	}
+	return nil
+}
+
+// formatFile formats a single file and reports whether its content differs
+// from the canonical form.
+func formatFile(fileName string, check bool) (bool, error) {
+	source, err := os.ReadFile(fileName)
+	if err != nil {
+		return false, err
+	}
+
+	formatted, err := automation.Format(fileName, string(source))
+	if err != nil {
+		return false, err
+	}
+
+	if bytes.Equal(source, formatted) {
+		return false, nil
+	}
+
+	if check {
+		fmt.Print(unifiedDiff(fileName, string(source), string(formatted)))
+		return true, nil
+	}
+
+	info, err := os.Stat(fileName)
+	if err != nil {
+		return true, err
+	}
+	if err := os.WriteFile(fileName, formatted, info.Mode().Perm()); err != nil {
+		return true, fmt.Errorf("failed to write %s: %w", fileName, err)
+	}
+	return true, nil
+}
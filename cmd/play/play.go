@@ -1,54 +1,161 @@
 package play
 
 import (
+	"cmp"
 	"fmt"
 	"log"
+	"strings"
 	"time"
 
 	"github.com/ebitengine/oto/v3"
+	"github.com/fruity-loozrz/go-scratchpad/internal/click"
+	"github.com/fruity-loozrz/go-scratchpad/internal/ring"
 	"github.com/fruity-loozrz/go-scratchpad/internal/scratch"
+	"github.com/fruity-loozrz/go-scratchpad/internal/session"
 	"github.com/spf13/cobra"
 )
 
-var automationFile string
+var (
+	automationFile string
+	quality        string
+	boundary       string
+	pan            float64
+	deckSpecs      []string
+	watch          bool
+	interactive    bool
+	loop           string
+	from           float64
+	to             float64
+	clickOn        bool
+	beatFile       string
+	beatBpm        float64
+	beatFit        string
+	beatGain       float64
+	timeSignature  string
+)
 
 func NewPlayCmd() *cobra.Command {
 	cmd := &cobra.Command{
-		Use:   "play [sound file]",
+		Use:   "play [sound file | session file]",
 		Short: "Play a sound file with automation",
-		Long:  `Play a sound file with automation from a specified automation file.`,
-		Args:  cobra.ExactArgs(1),
+		Long: `Play a sound file (WAV, AIFF or FLAC) with automation from a specified automation file.
+
+More decks, scratched or played straight as a backing track, are mixed in with --deck.
+A session file (.yaml) describes the decks instead; flags override its settings.`,
+		Args: cobra.MaximumNArgs(1),
 		Run: func(cmd *cobra.Command, args []string) {
-			soundFile := args[0]
+			file, err := session.FromArgs(args, automationFile, pan, deckSpecs)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			flags := cmd.Flags()
+			if flags.Changed("quality") {
+				if file.Quality, err = ring.ParseQuality(quality); err != nil {
+					log.Fatal(err)
+				}
+			}
+			if boundary != "" {
+				if file.Boundary, err = ring.ParseBoundary(boundary); err != nil {
+					log.Fatal(err)
+				}
+			}
+			if loop != "" {
+				if file.Loop, err = session.ParseLoop(loop); err != nil {
+					log.Fatal(err)
+				}
+			}
+			file.From, file.To = from, to
+			file.Click = clickOn
+			if file.TimeSignature, err = click.ParseTimeSignature(timeSignature); err != nil {
+				log.Fatal(err)
+			}
+			if beatFile != "" {
+				file.Beat = session.NewBeat(beatFile)
+			}
+			if beat := file.Beat; beat != nil {
+				if flags.Changed("beat-bpm") {
+					beat.Bpm = beatBpm
+				}
+				if flags.Changed("beat-fit") {
+					if beat.Fit, err = scratch.ParseFit(beatFit); err != nil {
+						log.Fatal(err)
+					}
+				}
+				if flags.Changed("beat-gain") {
+					beat.Gain = beatGain
+				}
+			} else if flags.Changed("beat-bpm") || flags.Changed("beat-fit") || flags.Changed("beat-gain") {
+				log.Fatal("--beat-bpm, --beat-fit and --beat-gain need a beat")
+			}
 
-			if err := runPlay(soundFile, automationFile); err != nil {
+			if err := runPlay(file.Session, watch, interactive); err != nil {
 				log.Fatal(err)
 			}
 		},
 	}
 
-	cmd.Flags().StringVarP(&automationFile, "automation", "a", "", "automation file (required)")
-	cmd.MarkFlagRequired("automation")
+	cmd.Flags().StringVarP(&automationFile, "automation", "a", "", "automation file (required with a sound file)")
+	cmd.Flags().StringVarP(&quality, "quality", "q", string(ring.DefaultQuality), "resampling quality: linear or sinc")
+	cmd.Flags().StringVarP(&boundary, "boundary", "b", "", "what plays outside the sample: wrap, clamp, silence or pingpong (overrides the automation file)")
+	cmd.Flags().Float64Var(&pan, "pan", 0, "pan of the sound file, from -1 (left) to 1 (right)")
+	cmd.Flags().BoolVarP(&watch, "watch", "w", false, "loop playback and reload the automation files when they change")
+	cmd.Flags().BoolVarP(&interactive, "tui", "i", false, "show the beat, move and head position in the terminal, with keys to pause, restart, skip, loop and nudge the tempo")
+	cmd.Flags().StringVar(&loop, "loop", "", "how many times to play, or forever")
+	cmd.Flags().Float64Var(&from, "from", 0, "beat of the automation to start playing from")
+	cmd.Flags().Float64Var(&to, "to", 0, "beat of the automation to stop playing at (defaults to the end)")
+	cmd.Flags().BoolVar(&clickOn, "click", false, "mix in a metronome on the beats of the automation, with accented downbeats")
+	cmd.Flags().StringVar(&timeSignature, "time-signature", click.DefaultTimeSignature.String(), "time signature of the click, as beats/unit")
+	cmd.Flags().StringVar(&beatFile, "beat", "", "drum loop to play under the automation, following its tempo (replaces the beat of the session file)")
+	cmd.Flags().Float64Var(&beatBpm, "beat-bpm", 0, "tempo of the beat (defaults to its acid chunk or a tempo in its name, as in break-92bpm.wav)")
+	cmd.Flags().StringVar(&beatFit, "beat-fit", string(scratch.DefaultFit), "how the beat follows the tempo: repitch or stretch")
+	cmd.Flags().Float64Var(&beatGain, "beat-gain", 1, "gain of the beat")
+	cmd.Flags().StringArrayVar(&deckSpecs, "deck", nil, "another deck to mix in, as sound=file[,automation=file][,gain=x][,pan=x]; without automation it plays straight")
 
 	return cmd
 }
 
-func runPlay(wavFileName, automationFileName string) error {
-	scr := scratch.NewScratch()
-	defer scr.Close()
-	if err := scr.SetWavFileName(wavFileName); err != nil {
+func runPlay(s session.Session, watch, interactive bool) error {
+	mixed, err := session.Open(s)
+	if err != nil {
 		return err
 	}
-	if err := scr.SetAutomationFileName(automationFileName); err != nil {
-		return err
+	defer mixed.Close()
+
+	// The terminal UI shows messages itself rather than log them over its
+	// display
+	var ui *tui
+	report := func(message string) { log.Print(message) }
+	if interactive {
+		if ui, err = newTUI(mixed, cmp.Or(s.TimeSignature, click.DefaultTimeSignature)); err != nil {
+			return err
+		}
+		report = ui.report
 	}
-	if err := scr.Init(); err != nil {
-		return err
+
+	if watch {
+		fileNames := mixed.AutomationFiles()
+		if len(fileNames) == 0 {
+			return fmt.Errorf("--watch needs a deck with an automation file")
+		}
+		mixed.SetLoop(true)
+
+		stop := make(chan struct{})
+		defer close(stop)
+		go watchFiles(fileNames, func() {
+			// Errors are reported and the current automation keeps playing
+			if err := mixed.Reload(); err != nil {
+				report(err.Error())
+				return
+			}
+			report("Reloaded automation, playing it from the next loop")
+		}, stop)
+		report(fmt.Sprintf("Watching %s for changes", strings.Join(fileNames, ", ")))
 	}
 
 	op := &oto.NewContextOptions{
-		SampleRate:   int(scr.SampleRate()),
-		ChannelCount: scr.NumChannels(),
+		SampleRate:   int(mixed.SampleRate()),
+		ChannelCount: mixed.NumChannels(),
 		Format:       oto.FormatFloat32LE,
 	}
 
@@ -58,9 +165,13 @@ func runPlay(wavFileName, automationFileName string) error {
 	}
 	<-readyChan
 
-	player := ctx.NewPlayer(scr)
+	player := ctx.NewPlayer(mixed)
 	player.Play()
 
+	if ui != nil {
+		return ui.run(player)
+	}
+
 	for {
 		if !player.IsPlaying() {
 			break
@@ -0,0 +1,313 @@
+package play
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ebitengine/oto/v3"
+	"github.com/fruity-loozrz/go-scratchpad/internal/click"
+	"github.com/fruity-loozrz/go-scratchpad/internal/ring"
+	"github.com/fruity-loozrz/go-scratchpad/internal/scratch"
+	"github.com/fruity-loozrz/go-scratchpad/internal/session"
+	"golang.org/x/term"
+)
+
+const (
+	// tuiRefresh is how often the display is redrawn.
+	tuiRefresh = 50 * time.Millisecond
+	// tempoNudge is the step of the tempo keys, as a fraction of the tempo.
+	tempoNudge = 0.01
+	// skipSeconds is how far the skip keys move.
+	skipSeconds = 1
+	// meterWidth is the width of the head position meter in characters.
+	meterWidth = 40
+)
+
+const tuiHelp = "space pause · r restart · ,/. skip · l loop · b loop bar · [/] widen · x whole routine · +/- tempo · 0 reset tempo · q quit"
+
+// tui shows the playback of a mix in the terminal and reads transport keys.
+type tui struct {
+	mix     *session.Mix
+	deck    session.Deck
+	scratch *scratch.Scratch
+	// signature sets the bars the bar keys loop.
+	signature click.TimeSignature
+	// frameSize is the size of an output frame in bytes, to tell how much
+	// audio the player still holds.
+	frameSize int
+
+	paused bool
+	nudge  float64
+	drawn  int
+	source sourceLines
+	// section is the beats the decks play from and to while sectioned, to
+	// being 0 for the end.
+	section   [2]float64
+	sectioned bool
+
+	mu      sync.Mutex
+	message string
+}
+
+func newTUI(mix *session.Mix, signature click.TimeSignature) (*tui, error) {
+	if !term.IsTerminal(int(os.Stdin.Fd())) || !term.IsTerminal(int(os.Stdout.Fd())) {
+		return nil, fmt.Errorf("--tui needs a terminal")
+	}
+	deck, scr, ok := mix.Lead()
+	if !ok {
+		return nil, fmt.Errorf("--tui needs a deck with automation")
+	}
+	return &tui{
+		mix:       mix,
+		deck:      deck,
+		scratch:   scr,
+		signature: signature,
+		frameSize: mix.NumChannels() * ring.SizeofFloat32,
+		source:    sourceLines{fileName: deck.Automation, routine: deck.Routine},
+	}, nil
+}
+
+// report shows a message under the display. It may be called from any
+// goroutine.
+func (t *tui) report(message string) {
+	t.mu.Lock()
+	t.message = message
+	t.mu.Unlock()
+}
+
+// run shows the display and handles keys until the user quits.
+func (t *tui) run(player *oto.Player) error {
+	fd := int(os.Stdin.Fd())
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return err
+	}
+	defer term.Restore(fd, state)
+	fmt.Print("\x1b[?25l")
+	defer fmt.Print("\x1b[?25h")
+
+	keys := make(chan byte)
+	go func() {
+		buf := make([]byte, 1)
+		for {
+			if n, err := os.Stdin.Read(buf); err != nil {
+				close(keys)
+				return
+			} else if n == 1 {
+				keys <- buf[0]
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(tuiRefresh)
+	defer ticker.Stop()
+	for {
+		t.draw(player)
+		select {
+		case key, ok := <-keys:
+			if !ok || !t.handle(key, player) {
+				return nil
+			}
+		case <-ticker.C:
+		}
+	}
+}
+
+// handle acts on a key, returning false to quit.
+func (t *tui) handle(key byte, player *oto.Player) bool {
+	switch key {
+	case 'q', 'Q', 3, 4, 27:
+		// q, ctrl-c, ctrl-d or escape
+		return false
+	case ' ':
+		t.paused = !t.paused
+		if t.paused {
+			player.Pause()
+		} else {
+			player.Play()
+		}
+	case 'r', 'R':
+		t.seek(player, 0, io.SeekStart)
+	case ',', '<':
+		t.seek(player, -t.skipSize(), io.SeekCurrent)
+	case '.', '>':
+		t.seek(player, t.skipSize(), io.SeekCurrent)
+	case 'l', 'L':
+		t.mix.SetLoop(!t.mix.Loop())
+	case 'b', 'B':
+		t.loopBar(player)
+	case '[', '{':
+		if !t.sectioned {
+			t.loopBar(player)
+		} else {
+			t.setSection(max(t.section[0]-t.signature.BarLength(), 0), t.section[1])
+		}
+	case ']', '}':
+		if !t.sectioned {
+			t.loopBar(player)
+		} else if t.section[1] != 0 {
+			to := t.section[1] + t.signature.BarLength()
+			if to >= t.scratch.Program().Beats() {
+				// Up to the end
+				to = 0
+			}
+			t.setSection(t.section[0], to)
+		}
+	case 'x', 'X':
+		if err := t.mix.SetSection(0, 0); err != nil {
+			t.report(err.Error())
+		} else {
+			t.sectioned = false
+		}
+	case '+', '=':
+		t.nudge += tempoNudge
+		t.mix.SetSpeed(1 + t.nudge)
+	case '-', '_':
+		t.nudge = max(t.nudge-tempoNudge, tempoNudge-0.5)
+		t.mix.SetSpeed(1 + t.nudge)
+	case '0':
+		t.nudge = 0
+		t.mix.SetSpeed(1)
+	}
+	return true
+}
+
+// seek moves playback, dropping the audio the player holds, and plays on.
+func (t *tui) seek(player *oto.Player, offset int64, whence int) {
+	if _, err := player.Seek(offset, whence); err != nil {
+		// Skipping back past the start restarts
+		_, _ = player.Seek(0, io.SeekStart)
+	}
+	t.paused = false
+	player.Play()
+}
+
+// loopBar loops the bar playing now.
+func (t *tui) loopBar(player *oto.Player) {
+	from, to := t.signature.Bar(t.position(player).Beat)
+	if to >= t.scratch.Program().Beats() {
+		// The last bar may be cut short
+		to = 0
+	}
+	t.setSection(from, to)
+}
+
+// setSection loops the decks from one beat to another, to being 0 for the
+// end.
+func (t *tui) setSection(from, to float64) {
+	if err := t.mix.SetSection(from, to); err != nil {
+		t.report(err.Error())
+		return
+	}
+	t.section, t.sectioned = [2]float64{from, to}, true
+	t.mix.SetLoop(true)
+}
+
+// position returns where the lead deck is in what the player plays now.
+func (t *tui) position(player *oto.Player) scratch.Position {
+	// The player holds audio that was read but not heard yet
+	buffered := float64(player.BufferedSize()/t.frameSize) / float64(t.mix.SampleRate())
+	now := max(t.scratch.Time()-buffered*(1+t.nudge), 0)
+	position, _ := t.scratch.PositionAt(now)
+	return position
+}
+
+// skipSize returns the number of bytes the skip keys move by.
+func (t *tui) skipSize() int64 {
+	return int64(t.mix.SampleRate()) * int64(skipSeconds) * int64(t.frameSize)
+}
+
+// draw redraws the display over the previous one.
+func (t *tui) draw(player *oto.Player) {
+	position := t.position(player)
+
+	status := "playing"
+	switch {
+	case t.paused:
+		status = "paused"
+	case !player.IsPlaying():
+		status = "ended"
+	}
+	loop := "off"
+	switch {
+	case t.mix.Loop() && t.sectioned && t.section[1] == 0:
+		loop = fmt.Sprintf("beats %g-end", t.section[0])
+	case t.mix.Loop() && t.sectioned:
+		loop = fmt.Sprintf("beats %g-%g", t.section[0], t.section[1])
+	case t.mix.Loop():
+		loop = "on"
+	}
+	name := t.deck.Automation
+	if name == "" {
+		name = "routine"
+	}
+
+	move := "-"
+	if position.Moving {
+		move = fmt.Sprintf("line %d: %s", position.Line, t.source.line(position.Line))
+	}
+
+	t.mu.Lock()
+	message := t.message
+	t.mu.Unlock()
+
+	lines := []string{
+		fmt.Sprintf("%s · %s · loop %s · tempo %+.0f%%", name, status, loop, t.nudge*100),
+		fmt.Sprintf("beat %6.2f / %g · %.1f bpm", position.Beat, position.Beats, position.Bpm*(1+t.nudge)),
+		move,
+		fmt.Sprintf("head %s %6.3fs", meter(position.Head, t.scratch.SampleDuration().Seconds()), position.Head),
+		tuiHelp,
+		message,
+	}
+
+	var b strings.Builder
+	if t.drawn > 0 {
+		fmt.Fprintf(&b, "\x1b[%dA", t.drawn)
+	}
+	for _, line := range lines {
+		b.WriteString("\r\x1b[K" + line + "\r\n")
+	}
+	t.drawn = len(lines)
+	fmt.Print(b.String())
+}
+
+// meter draws the position of the head in a sample of the given length.
+func meter(head, length float64) string {
+	cells := []rune(strings.Repeat("─", meterWidth))
+	if length > 0 {
+		i := int(math.Floor(head / length * float64(meterWidth)))
+		cells[min(max(i, 0), meterWidth-1)] = '█'
+	}
+	return "[" + string(cells) + "]"
+}
+
+// sourceLines reads the lines of the automation, reading the file again
+// when it changes.
+type sourceLines struct {
+	fileName string
+	routine  string
+	modTime  time.Time
+	lines    []string
+}
+
+func (s *sourceLines) line(n int) string {
+	if s.fileName != "" {
+		if t := modTime(s.fileName); s.lines == nil || !t.Equal(s.modTime) {
+			s.modTime = t
+			data, _ := os.ReadFile(s.fileName)
+			s.lines = strings.Split(string(data), "\n")
+		}
+	} else if s.lines == nil {
+		s.lines = strings.Split(s.routine, "\n")
+	}
+
+	if n < 1 || n > len(s.lines) {
+		return ""
+	}
+	return strings.TrimSpace(s.lines[n-1])
+}
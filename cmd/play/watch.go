@@ -0,0 +1,54 @@
+package play
+
+import (
+	"log"
+	"os"
+	"time"
+)
+
+// watchInterval is how often watched files are checked for changes.
+const watchInterval = 250 * time.Millisecond
+
+// watchFiles calls onChange whenever one of the files is modified, until
+// stop is closed. Files are polled, which works the same on every platform
+// and with editors that replace files rather than write them.
+func watchFiles(fileNames []string, onChange func(), stop <-chan struct{}) {
+	modTimes := make([]time.Time, len(fileNames))
+	for i, fileName := range fileNames {
+		modTimes[i] = modTime(fileName)
+	}
+
+	ticker := time.NewTicker(watchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		changed := false
+		for i, fileName := range fileNames {
+			if t := modTime(fileName); !t.Equal(modTimes[i]) {
+				modTimes[i] = t
+				changed = true
+			}
+		}
+		if changed {
+			onChange()
+		}
+	}
+}
+
+// modTime returns the modification time of a file, or the zero time while it
+// cannot be read, e.g. halfway through an editor replacing it.
+func modTime(fileName string) time.Time {
+	info, err := os.Stat(fileName)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Print(err)
+		}
+		return time.Time{}
+	}
+	return info.ModTime()
+}
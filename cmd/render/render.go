@@ -1,6 +1,7 @@
 package render
 
 import (
+	"cmp"
 	"encoding/binary"
 	"fmt"
 	"io"
@@ -8,121 +9,262 @@ import (
 	"math"
 	"os"
 
+	"github.com/fruity-loozrz/go-scratchpad/internal/click"
+	"github.com/fruity-loozrz/go-scratchpad/internal/ring"
 	"github.com/fruity-loozrz/go-scratchpad/internal/scratch"
+	"github.com/fruity-loozrz/go-scratchpad/internal/session"
+	"github.com/fruity-loozrz/go-scratchpad/internal/wavout"
 	"github.com/spf13/cobra"
-	"github.com/youpy/go-wav"
 )
 
 var (
 	automationFile string
 	outputFile     string
+	quality        string
+	boundary       string
+	bitDepth       string
+	sampleRate     uint32
+	dither         bool
+	channels       int
+	pan            float64
+	deckSpecs      []string
+	loop           string
+	from           float64
+	to             float64
+	clickOn        bool
+	beatFile       string
+	beatBpm        float64
+	beatFit        string
+	beatGain       float64
+	clickOnly      bool
+	timeSignature  string
 )
 
+// stdoutFileName is the output file name that writes to stdout.
+const stdoutFileName = "-"
+
+// renderOptions are the settings of a render beside its input and output
+// files.
+type renderOptions struct {
+	quality    ring.Quality
+	boundary   ring.Boundary
+	bitDepth   wavout.BitDepth
+	sampleRate uint32
+	dither     bool
+	// channels is the number of output channels, or 0 to keep those of the
+	// sound files.
+	channels int
+	// from, to and loop are the section of the automation rendered and how
+	// many times, as in session.Session.
+	from, to float64
+	loop     int
+	// click mixes in a metronome, and clickOnly renders it alone.
+	click         bool
+	clickOnly     bool
+	timeSignature click.TimeSignature
+	// beat is a drum loop played under the decks, or nil.
+	beat *session.Beat
+}
+
 func NewRenderCmd() *cobra.Command {
 	cmd := &cobra.Command{
-		Use:   "render [sound file]",
+		Use:   "render [sound file | session file]",
 		Short: "Render a sound file with automation to WAV",
-		Long:  `Render a sound file with automation from a specified automation file and save the output as a WAV file.`,
-		Args:  cobra.ExactArgs(1),
+		Long: `Render a sound file (WAV, AIFF or FLAC) with automation from a specified automation file and save the output as a WAV file.
+
+More decks, scratched or played straight as a backing track, are mixed in with --deck.
+A session file (.yaml) describes the decks and the output instead; flags override its settings.`,
+		Args: cobra.MaximumNArgs(1),
 		Run: func(cmd *cobra.Command, args []string) {
-			soundFile := args[0]
+			file, err := session.FromArgs(args, automationFile, pan, deckSpecs)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			flags := cmd.Flags()
+			options := renderOptions{
+				bitDepth:   file.Output.BitDepth,
+				sampleRate: file.SampleRate,
+				dither:     file.Output.Dither,
+				channels:   file.NumChannels,
+			}
+			if flags.Changed("quality") {
+				if options.quality, err = ring.ParseQuality(quality); err != nil {
+					log.Fatal(err)
+				}
+			}
+			if boundary != "" {
+				if options.boundary, err = ring.ParseBoundary(boundary); err != nil {
+					log.Fatal(err)
+				}
+			}
+			if flags.Changed("bit-depth") || options.bitDepth == "" {
+				if options.bitDepth, err = wavout.ParseBitDepth(bitDepth); err != nil {
+					log.Fatal(err)
+				}
+			}
+			if flags.Changed("sample-rate") {
+				options.sampleRate = sampleRate
+			}
+			if flags.Changed("dither") {
+				options.dither = dither
+			}
+			if flags.Changed("channels") {
+				options.channels = channels
+			}
+			if loop != "" {
+				if options.loop, err = session.ParseLoop(loop); err != nil {
+					log.Fatal(err)
+				}
+			}
+			options.from, options.to = from, to
+			options.click, options.clickOnly = clickOn, clickOnly
+			if options.timeSignature, err = click.ParseTimeSignature(timeSignature); err != nil {
+				log.Fatal(err)
+			}
+			if beatFile != "" {
+				file.Beat = session.NewBeat(beatFile)
+			}
+			if beat := file.Beat; beat != nil {
+				if flags.Changed("beat-bpm") {
+					beat.Bpm = beatBpm
+				}
+				if flags.Changed("beat-fit") {
+					if beat.Fit, err = scratch.ParseFit(beatFit); err != nil {
+						log.Fatal(err)
+					}
+				}
+				if flags.Changed("beat-gain") {
+					beat.Gain = beatGain
+				}
+			} else if flags.Changed("beat-bpm") || flags.Changed("beat-fit") || flags.Changed("beat-gain") {
+				log.Fatal("--beat-bpm, --beat-fit and --beat-gain need a beat")
+			}
 
-			if err := runRender(soundFile, automationFile, outputFile); err != nil {
+			output := cmp.Or(outputFile, file.Output.File)
+			if output == "" {
+				log.Fatal(`required flag "output" not set`)
+			}
+			if options.loop == session.LoopForever && output != stdoutFileName {
+				log.Fatal("--loop forever only renders to stdout")
+			}
+
+			options.beat = file.Beat
+
+			if err := runRender(file.Decks, output, options); err != nil {
 				log.Fatal(err)
 			}
 		},
 	}
 
-	cmd.Flags().StringVarP(&automationFile, "automation", "a", "", "automation file (required)")
-	cmd.Flags().StringVarP(&outputFile, "output", "o", "", "output WAV file (required)")
-	cmd.MarkFlagRequired("automation")
-	cmd.MarkFlagRequired("output")
+	cmd.Flags().StringVarP(&automationFile, "automation", "a", "", "automation file (required with a sound file)")
+	cmd.Flags().StringVarP(&outputFile, "output", "o", "", "output WAV file, or - for stdout (required unless the session file names one)")
+	cmd.Flags().StringVarP(&quality, "quality", "q", string(ring.DefaultQuality), "resampling quality: linear or sinc")
+	cmd.Flags().StringVarP(&boundary, "boundary", "b", "", "what plays outside the sample: wrap, clamp, silence or pingpong (overrides the automation file)")
+	cmd.Flags().StringVar(&bitDepth, "bit-depth", string(wavout.DefaultBitDepth), "output sample format: 16, 24 or 32f (32-bit float)")
+	cmd.Flags().Uint32Var(&sampleRate, "sample-rate", 0, "output sample rate in Hz (defaults to the rate of the sound file)")
+	cmd.Flags().BoolVar(&dither, "dither", false, "add TPDF dither when writing 16 or 24-bit output")
+	cmd.Flags().IntVar(&channels, "channels", 0, "number of output channels, mixed down or up from the sound files (defaults to their channels)")
+	cmd.Flags().Float64Var(&pan, "pan", 0, "pan of the sound file, from -1 (left) to 1 (right)")
+	cmd.Flags().StringVar(&loop, "loop", "", "how many times to render the automation, or forever (to stdout only)")
+	cmd.Flags().Float64Var(&from, "from", 0, "beat of the automation to start rendering from")
+	cmd.Flags().Float64Var(&to, "to", 0, "beat of the automation to stop rendering at (defaults to the end)")
+	cmd.Flags().BoolVar(&clickOn, "click", false, "mix in a metronome on the beats of the automation, with accented downbeats")
+	cmd.Flags().BoolVar(&clickOnly, "click-only-stem", false, "render the metronome alone, as a stem to line up with the full render")
+	cmd.Flags().StringVar(&timeSignature, "time-signature", click.DefaultTimeSignature.String(), "time signature of the click, as beats/unit")
+	cmd.Flags().StringVar(&beatFile, "beat", "", "drum loop to play under the automation, following its tempo (replaces the beat of the session file)")
+	cmd.Flags().Float64Var(&beatBpm, "beat-bpm", 0, "tempo of the beat (defaults to its acid chunk or a tempo in its name, as in break-92bpm.wav)")
+	cmd.Flags().StringVar(&beatFit, "beat-fit", string(scratch.DefaultFit), "how the beat follows the tempo: repitch or stretch")
+	cmd.Flags().Float64Var(&beatGain, "beat-gain", 1, "gain of the beat")
+	cmd.Flags().StringArrayVar(&deckSpecs, "deck", nil, "another deck to mix in, as sound=file[,automation=file][,gain=x][,pan=x]; without automation it plays straight")
 
 	return cmd
 }
 
-func runRender(wavFileName, automationFileName, outputFileName string) error {
-	scr := scratch.NewScratch()
-	defer scr.Close()
-	if err := scr.SetWavFileName(wavFileName); err != nil {
-		return err
-	}
-	if err := scr.SetAutomationFileName(automationFileName); err != nil {
+func runRender(decks []session.Deck, outputFileName string, options renderOptions) error {
+	mixed, err := session.Open(session.Session{
+		Decks:         decks,
+		Quality:       options.quality,
+		Boundary:      options.boundary,
+		SampleRate:    options.sampleRate,
+		NumChannels:   options.channels,
+		From:          options.from,
+		To:            options.to,
+		Loop:          options.loop,
+		Click:         options.click,
+		ClickOnly:     options.clickOnly,
+		TimeSignature: options.timeSignature,
+		Beat:          options.beat,
+	})
+	if err != nil {
 		return err
 	}
-	if err := scr.Init(); err != nil {
-		return err
+	defer mixed.Close()
+
+	sampleRate := mixed.SampleRate()
+	numChannels := mixed.NumChannels()
+
+	// Write to stdout for "-", so that renders can be piped into other tools
+	var out io.Writer = os.Stdout
+	report := os.Stdout
+	if outputFileName == stdoutFileName {
+		report, outputFileName = os.Stderr, "stdout"
+	} else {
+		outFile, err := os.Create(outputFileName)
+		if err != nil {
+			return fmt.Errorf("failed to create output file: %w", err)
+		}
+		defer outFile.Close()
+		out = outFile
 	}
 
-	sampleRate := scr.SampleRate()
-	numChannels := scr.NumChannels()
+	format := wavout.Format{
+		SampleRate:  sampleRate,
+		NumChannels: numChannels,
+		BitDepth:    options.bitDepth,
+	}
+	writer, err := wavout.NewStreamWriter(out, format)
+	if err != nil {
+		return fmt.Errorf("failed to write header: %w", err)
+	}
+	writer.SetDither(options.dither)
 
-	// Read all audio data from the scratch buffer
+	// Write the audio chunk by chunk as the scratch produces it
 	const bufferSize = 4096
 	readBuffer := make([]byte, bufferSize)
-	var allSamples []wav.Sample
-
+	numFrames := 0
 	for {
-		n, err := scr.Read(readBuffer)
-		if err == io.EOF {
+		n, readErr := mixed.Read(readBuffer)
+		samples := convertBytesToFloat32(readBuffer[:n])
+		if err := writer.WriteFrames(samples); err != nil {
+			return fmt.Errorf("failed to write samples: %w", err)
+		}
+		numFrames += len(samples) / numChannels
+
+		if readErr == io.EOF {
 			break
 		}
-		if err != nil {
-			return fmt.Errorf("failed to read audio data: %w", err)
+		if readErr != nil {
+			return fmt.Errorf("failed to read audio data: %w", readErr)
 		}
-
-		// Convert float32 LE bytes to 16-bit PCM samples
-		samples := convertFloat32ToInt16(readBuffer[:n], numChannels)
-		allSamples = append(allSamples, samples...)
 	}
-
-	// Create output file
-	outFile, err := os.Create(outputFileName)
-	if err != nil {
-		return fmt.Errorf("failed to create output file: %w", err)
-	}
-	defer outFile.Close()
-
-	// Write WAV file
-	numSamples := uint32(len(allSamples))
-	writer := wav.NewWriter(outFile, numSamples, uint16(numChannels), sampleRate, 16)
-	if err := writer.WriteSamples(allSamples); err != nil {
+	if err := writer.Close(); err != nil {
 		return fmt.Errorf("failed to write samples: %w", err)
 	}
 
-	fmt.Printf("Rendered %d samples to %s (%.2f seconds)\n",
-		numSamples, outputFileName, float64(numSamples)/float64(sampleRate))
+	fmt.Fprintf(report, "Rendered %d samples to %s (%.2f seconds)\n",
+		numFrames, outputFileName, float64(numFrames)/float64(sampleRate))
 
 	return nil
 }
 
-// convertFloat32ToInt16 converts a buffer of float32 LE samples to 16-bit PCM samples
-func convertFloat32ToInt16(buffer []byte, numChannels int) []wav.Sample {
-	const sizeofFloat32 = 4
-	numSamples := len(buffer) / sizeofFloat32 / numChannels
-	samples := make([]wav.Sample, numSamples)
-
-	for i := range numSamples {
-		for ch := range min(numChannels, 2) {
-			offset := (i*numChannels + ch) * sizeofFloat32
-
-			// Read float32 value (Little Endian)
-			bits := binary.LittleEndian.Uint32(buffer[offset : offset+sizeofFloat32])
-			floatValue := math.Float32frombits(bits)
-
-			// Convert float32 [-1.0, 1.0] to 16-bit int [-32768, 32767]
-			scaled := floatValue * 32767.0
-
-			// Clamp to prevent overflow
-			if scaled > 32767.0 {
-				scaled = 32767.0
-			} else if scaled < -32768.0 {
-				scaled = -32768.0
-			}
-
-			samples[i].Values[ch] = int(scaled)
-		}
+// convertBytesToFloat32 converts a buffer of float32 LE samples, as read
+// from the ring, to samples
+func convertBytesToFloat32(buffer []byte) []float32 {
+	samples := make([]float32, len(buffer)/ring.SizeofFloat32)
+	for i := range samples {
+		bits := binary.LittleEndian.Uint32(buffer[i*ring.SizeofFloat32:])
+		samples[i] = math.Float32frombits(bits)
 	}
-
 	return samples
 }
@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"github.com/fruity-loozrz/go-scratchpad/cmd/format"
 	"github.com/fruity-loozrz/go-scratchpad/cmd/play"
 	"github.com/fruity-loozrz/go-scratchpad/cmd/render"
 	"github.com/spf13/cobra"
@@ -19,4 +20,5 @@ func Execute() error {
 func init() {
 	rootCmd.AddCommand(play.NewPlayCmd())
 	rootCmd.AddCommand(render.NewRenderCmd())
+	rootCmd.AddCommand(format.NewFormatCmd())
 }
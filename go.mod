@@ -5,8 +5,10 @@ go 1.25.5
 require (
 	github.com/ebitengine/oto/v3 v3.4.0
 	github.com/spf13/cobra v1.10.2
-	github.com/youpy/go-wav v0.3.2
+	github.com/zaf/g711 v0.0.0-20190814101024-76a4a538f52b
+	golang.org/x/term v0.35.0
 	gonum.org/v1/gonum v0.16.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -14,9 +16,6 @@ require (
 	github.com/inconshreveable/mousetrap v1.1.0 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 	github.com/spf13/pflag v1.0.9 // indirect
-	github.com/youpy/go-riff v0.1.0 // indirect
-	github.com/zaf/g711 v0.0.0-20190814101024-76a4a538f52b // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
 
 require (
@@ -0,0 +1,185 @@
+package automation
+
+import (
+	"bufio"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// NodeKind tells what a line of an automation source holds.
+type NodeKind int
+
+const (
+	// BlankNode is an empty line.
+	BlankNode NodeKind = iota
+	// CommentNode is a line holding only a comment.
+	CommentNode
+	// CommandNode is a line holding a command, possibly with a comment.
+	CommandNode
+	// BlockNode is a command opening a block ("repeat 4 {"), its body and
+	// its closing line.
+	BlockNode
+)
+
+// Token is a whitespace separated word of a line, spelled as in the source.
+// Column is 1-based.
+type Token struct {
+	Text   string
+	Column int
+}
+
+// Node is a line of an automation source. Comment holds the text after the
+// "#" of a comment. Block nodes also carry their body and the closing line,
+// whose comment is kept in EndComment.
+type Node struct {
+	Kind       NodeKind
+	Line       int
+	Tokens     []Token
+	Comment    string
+	Body       []*Node
+	EndLine    int
+	EndComment string
+}
+
+// File is the syntax tree of an automation source. Unlike Program it keeps
+// comments, blank lines and the original spelling of every token, so it can
+// be printed back as text.
+type File struct {
+	Name  string
+	Nodes []*Node
+}
+
+// ParseAST parses an automation source into a syntax tree. Only the block
+// structure is checked; commands are validated by Parse.
+func ParseAST(fileName string, input string) (*File, error) {
+	errs := newErrorCollector(fileName)
+	file, err := parseAST(fileName, input, errs)
+	if err != nil {
+		return nil, err
+	}
+	if err := errs.err(); err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+func parseAST(fileName string, input string, errs *errorCollector) (*File, error) {
+	lines := []string{}
+	scanner := bufio.NewScanner(strings.NewReader(input))
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	nodes, _, _ := parseNodes(lines, 0, false, errs)
+	return &File{Name: fileName, Nodes: nodes}, nil
+}
+
+// tokenize splits a line into tokens and the comment that ends it. A double
+// quoted string is a single token, whatever it holds.
+func tokenize(line string) ([]Token, string, bool) {
+	tokens := []Token{}
+	var current []rune
+	start := 0
+
+	flush := func() {
+		if len(current) > 0 {
+			tokens = append(tokens, Token{Text: string(current), Column: start + 1})
+			current = nil
+		}
+	}
+
+	runes := []rune(line)
+	quoted, escaped := false, false
+	for column, r := range runes {
+		switch {
+		case quoted:
+			// Spaces and comment signs are part of a quoted string
+			if !escaped && string(r) == quoteToken {
+				quoted = false
+			}
+			escaped = !escaped && r == '\\'
+			current = append(current, r)
+			continue
+		case string(r) == commentToken:
+			flush()
+			return tokens, strings.TrimRightFunc(string(runes[column+1:]), unicode.IsSpace), true
+		case unicode.IsSpace(r):
+			flush()
+			continue
+		case string(r) == quoteToken:
+			quoted = true
+		}
+		if len(current) == 0 {
+			start = column
+		}
+		current = append(current, r)
+	}
+	flush()
+
+	return tokens, "", false
+}
+
+func isBlockStart(tokens []Token) bool {
+	return len(tokens) > 0 && tokens[len(tokens)-1].Text == blockStartToken
+}
+
+func isBlockEnd(tokens []Token) bool {
+	return len(tokens) > 0 && tokens[0].Text == blockEndToken
+}
+
+// parseNodes parses lines starting at pos until the end of the input or,
+// when nested, until the closing brace of the enclosing block. It returns the
+// nodes, the position of the first line that was not consumed and the
+// closing line when there is one.
+func parseNodes(lines []string, pos int, nested bool, errs *errorCollector) ([]*Node, int, *Node) {
+	nodes := []*Node{}
+	for pos < len(lines) {
+		tokens, comment, hasComment := tokenize(lines[pos])
+		node := &Node{
+			Line:    pos + 1,
+			Tokens:  tokens,
+			Comment: comment,
+		}
+		pos++
+
+		switch {
+		case len(tokens) == 0 && !hasComment:
+			node.Kind = BlankNode
+		case len(tokens) == 0:
+			node.Kind = CommentNode
+		case isBlockEnd(tokens):
+			if len(tokens) > 1 {
+				errs.add(node.Line, tokens, &syntaxError{token: 1, message: "unexpected token"})
+			}
+			if nested {
+				return nodes, pos, node
+			}
+			errs.add(node.Line, tokens, &syntaxError{token: 0, message: "unexpected block end"})
+			continue
+		case isBlockStart(tokens):
+			node.Kind = BlockNode
+			var end *Node
+			node.Body, pos, end = parseNodes(lines, pos, true, errs)
+			if end == nil {
+				errs.add(node.Line, tokens, &syntaxError{
+					token:   len(tokens) - 1,
+					message: "block is never closed",
+					hint:    "expected a matching " + strconv.Quote(blockEndToken),
+				})
+				break
+			}
+			node.EndLine = end.Line
+			node.EndComment = end.Comment
+		default:
+			node.Kind = CommandNode
+		}
+
+		nodes = append(nodes, node)
+	}
+
+	return nodes, pos, nil
+}
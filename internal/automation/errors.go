@@ -0,0 +1,101 @@
+package automation
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ParseError describes a problem at a position of an automation source.
+// Line and Column are 1-based; Column points at Token.
+type ParseError struct {
+	FileName string
+	Line     int
+	Column   int
+	Token    string
+	Message  string
+	Hint     string
+}
+
+func (e *ParseError) Error() string {
+	var sb strings.Builder
+	if e.FileName != "" {
+		sb.WriteString(e.FileName)
+		sb.WriteString(":")
+	}
+	fmt.Fprintf(&sb, "%d:%d: %s", e.Line, e.Column, e.Message)
+	if e.Token != "" {
+		fmt.Fprintf(&sb, " %q", e.Token)
+	}
+	if e.Hint != "" {
+		fmt.Fprintf(&sb, " (%s)", e.Hint)
+	}
+	return sb.String()
+}
+
+// ParseErrors is the list of all errors found in an automation source, in
+// the order they were found.
+type ParseErrors []*ParseError
+
+func (e ParseErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "\n")
+}
+
+// syntaxError describes a problem with one token of a line, before it is
+// placed in the source by errorCollector.
+type syntaxError struct {
+	token   int
+	message string
+	hint    string
+}
+
+// errorCollector accumulates parse errors, dropping the duplicates that are
+// found when the same pattern is expanded several times.
+type errorCollector struct {
+	fileName string
+	errors   ParseErrors
+	seen     map[string]bool
+}
+
+func newErrorCollector(fileName string) *errorCollector {
+	return &errorCollector{
+		fileName: fileName,
+		seen:     map[string]bool{},
+	}
+}
+
+func (c *errorCollector) add(line int, tokens []Token, err *syntaxError) {
+	parseError := &ParseError{
+		FileName: c.fileName,
+		Line:     line,
+		Column:   1,
+		Message:  err.message,
+		Hint:     err.hint,
+	}
+	if err.token < len(tokens) {
+		parseError.Column = tokens[err.token].Column
+		parseError.Token = tokens[err.token].Text
+	} else if len(tokens) > 0 {
+		// The token is missing: point just past the end of the line
+		last := tokens[len(tokens)-1]
+		parseError.Column = last.Column + len([]rune(last.Text))
+	}
+
+	key := fmt.Sprintf("%d:%d:%s", parseError.Line, parseError.Column, parseError.Message)
+	if c.seen[key] {
+		return
+	}
+	c.seen[key] = true
+	c.errors = append(c.errors, parseError)
+}
+
+// err returns the collected errors, or nil if there are none.
+func (c *errorCollector) err() error {
+	if len(c.errors) == 0 {
+		return nil
+	}
+	return c.errors
+}
@@ -0,0 +1,312 @@
+package automation
+
+import (
+	"fmt"
+
+	kf "github.com/fruity-loozrz/go-scratchpad/internal/keyframes"
+)
+
+// maxMoves caps the number of moves a program expands to, so that nested
+// repeat blocks and pattern uses cannot exhaust memory.
+const maxMoves = 100_000
+
+// evaluator applies parsed statements to a Program, expanding repeat blocks
+// and pattern uses into a flat list of moves. Statements with errors are
+// reported and skipped so that a single run finds as many errors as it can.
+type evaluator struct {
+	program            *Program
+	errs               *errorCollector
+	patterns           map[string][]statement
+	uses               []statement
+	beat               float64
+	interpolationIsSet bool
+	boundaryIsSet      bool
+	cueIsSet           bool
+	region             *statement
+	platter            kf.PlatterParams
+	platterStatements  []statement
+	motorOn            bool
+	platterSpeed       float64
+	brake              float64
+	startup            float64
+
+	// expansion is the outermost repeat block or pattern use being
+	// expanded, if any, and tooManyMoves whether the moves reached maxMoves,
+	// which stops the evaluation.
+	expansion    *statement
+	tooManyMoves bool
+	// lines are the lines the moves are written on, indexed like Moves.
+	lines []int
+}
+
+func newEvaluator(errs *errorCollector) *evaluator {
+	program := &Program{
+		Bpm:   defaultBpm,
+		Moves: []Move{},
+	}
+	program.SetInterpolationType(defaultInterpolationType)
+
+	return &evaluator{
+		program:      program,
+		errs:         errs,
+		patterns:     map[string][]statement{},
+		platter:      kf.DefaultPlatterParams,
+		motorOn:      true,
+		platterSpeed: 1.0,
+		brake:        defaultBrake,
+		startup:      defaultStartup,
+	}
+}
+
+func (e *evaluator) run(statements []statement) {
+	for _, statement := range statements {
+		if e.tooManyMoves {
+			return
+		}
+		e.apply(statement)
+	}
+}
+
+// expand runs the body of a repeat block or pattern use, remembering the
+// outermost one to blame for too many moves.
+func (e *evaluator) expand(statement statement, run func()) {
+	if e.expansion == nil {
+		e.expansion = &statement
+		defer func() { e.expansion = nil }()
+	}
+	run()
+}
+
+// finish applies the settings that depend on the whole program once all
+// statements have run.
+func (e *evaluator) finish() {
+	// Regions given by markers are checked once the markers are resolved
+	if region := e.program.Region; region != nil && !region.Start.isMarker() && !region.End.isMarker() {
+		if start, end, _ := e.program.RegionTimes(); start >= end {
+			e.errs.add(e.region.line, e.region.tokens, &syntaxError{
+				token:   2,
+				message: "region ends before it starts",
+				hint:    regionHint,
+			})
+		}
+	}
+
+	if predictor, ok := e.program.Predictor.(*kf.PlatterPredictor); ok {
+		predictor.Params = e.platter
+		return
+	}
+	for _, statement := range e.platterStatements {
+		e.errs.add(statement.line, statement.tokens, &syntaxError{
+			token:   0,
+			message: "platter settings need physical interpolation",
+			hint:    `add "interpolate physical"`,
+		})
+	}
+}
+
+// report records an error on the given token of a statement. Errors found
+// while expanding a pattern mention where the pattern was used.
+func (e *evaluator) report(statement statement, token int, message string) {
+	err := &syntaxError{token: token, message: message}
+	if n := len(e.uses); n > 0 {
+		use := e.uses[n-1]
+		err.hint = fmt.Sprintf("in pattern %q used at line %d", use.action.name, use.line)
+	}
+	e.errs.add(statement.line, statement.tokens, err)
+}
+
+func (e *evaluator) isExpanding(name string) bool {
+	for _, use := range e.uses {
+		if use.action.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *evaluator) apply(statement statement) {
+	action := statement.action
+
+	switch action.actionType {
+	case actionTypeMove:
+		{
+			if len(e.program.Moves) >= maxMoves {
+				blame := statement
+				if e.expansion != nil {
+					blame = *e.expansion
+				}
+				e.errs.add(blame.line, blame.tokens, &syntaxError{
+					message: fmt.Sprintf("expands to more than %d moves", maxMoves),
+				})
+				e.tooManyMoves = true
+				return
+			}
+			e.program.Moves = append(e.program.Moves, *action.move)
+			e.lines = append(e.lines, statement.line)
+			e.beat += action.move.Dt
+		}
+	case actionTypeBpm:
+		{
+			e.setTempo(TempoChange{
+				Beat:      e.beat,
+				Bpm:       action.bpm,
+				TargetBpm: action.bpmTarget,
+				RampBeats: action.bpmRamp,
+			})
+		}
+	case actionTypeInterpolation:
+		{
+			if e.interpolationIsSet {
+				e.report(statement, 0, "duplicate interpolation set")
+				return
+			}
+			e.program.SetInterpolationType(action.interpolationType)
+			e.interpolationIsSet = true
+		}
+	case actionTypePlatter:
+		{
+			switch action.platterParam {
+			case platterMassToken:
+				e.platter.Mass = action.platterValue
+			case platterTorqueToken:
+				e.platter.Torque = action.platterValue
+			case platterSlipToken:
+				e.platter.Slip = action.platterValue
+			}
+			e.platterStatements = append(e.platterStatements, statement)
+		}
+	case actionTypeRpm:
+		{
+			e.platterSpeed = action.rpm / nominalRpm
+			if e.motorOn {
+				e.setMotor(MotorChange{Beat: e.beat, Speed: e.platterSpeed, Ramp: e.startup})
+			}
+		}
+	case actionTypeMotor:
+		{
+			if action.motorOn == e.motorOn {
+				return
+			}
+			e.motorOn = action.motorOn
+			if e.motorOn {
+				e.setMotor(MotorChange{Beat: e.beat, Speed: e.platterSpeed, Ramp: e.startup})
+			} else {
+				e.setMotor(MotorChange{Beat: e.beat, Speed: 0, Ramp: e.brake})
+			}
+		}
+	case actionTypeBrake:
+		{
+			e.brake = action.seconds
+		}
+	case actionTypeStartup:
+		{
+			e.startup = action.seconds
+		}
+	case actionTypeBoundary:
+		{
+			if e.boundaryIsSet {
+				e.report(statement, 0, "duplicate boundary set")
+				return
+			}
+			e.program.Boundary = action.boundary
+			e.boundaryIsSet = true
+		}
+	case actionTypeCue:
+		{
+			if e.cueIsSet {
+				e.report(statement, 0, "duplicate cue set")
+				return
+			}
+			if len(e.program.Moves) > 0 {
+				e.report(statement, 0, "cue after the first move")
+				return
+			}
+			e.program.Cue = action.positions[0]
+			e.cueIsSet = true
+		}
+	case actionTypeRegion:
+		{
+			if e.region != nil {
+				e.report(statement, 0, "duplicate region set")
+				return
+			}
+			e.program.Region = &Region{Start: action.positions[0], End: action.positions[1]}
+			e.region = &statement
+		}
+	case actionTypeFader:
+		{
+			e.program.Fader = append(e.program.Fader, FaderChange{
+				Beat: e.beat,
+				Gain: action.faderGain,
+			})
+		}
+	case actionTypeRepeat:
+		{
+			e.expand(statement, func() {
+				for range action.count {
+					if e.tooManyMoves {
+						return
+					}
+					e.run(action.body)
+				}
+			})
+		}
+	case actionTypePattern:
+		{
+			if _, ok := e.patterns[action.name]; ok {
+				e.report(statement, 1, "duplicate pattern")
+				return
+			}
+			e.patterns[action.name] = action.body
+		}
+	case actionTypeUse:
+		{
+			body, ok := e.patterns[action.name]
+			if !ok {
+				e.report(statement, 1, "undefined pattern")
+				return
+			}
+			if e.isExpanding(action.name) {
+				e.report(statement, 1, "recursive use of pattern")
+				return
+			}
+			e.uses = append(e.uses, statement)
+			e.expand(statement, func() { e.run(body) })
+			e.uses = e.uses[:len(e.uses)-1]
+		}
+	default:
+		{
+			e.report(statement, 0, fmt.Sprintf("unknown action type %v", action.actionType))
+		}
+	}
+}
+
+// setTempo records a tempo change at the current beat. A plain tempo set
+// before the first move becomes the program's initial tempo, and a later
+// change at the same beat replaces the earlier one.
+func (e *evaluator) setTempo(change TempoChange) {
+	if change.Beat == 0 {
+		e.program.Bpm = change.Bpm
+		e.program.Tempo = nil
+		if change.RampBeats > 0 {
+			e.program.Tempo = []TempoChange{change}
+		}
+		return
+	}
+
+	if n := len(e.program.Tempo); n > 0 && e.program.Tempo[n-1].Beat == change.Beat {
+		e.program.Tempo[n-1] = change
+		return
+	}
+	e.program.Tempo = append(e.program.Tempo, change)
+}
+
+// setMotor records a platter speed change at the current beat, replacing an
+// earlier change at the same beat.
+func (e *evaluator) setMotor(change MotorChange) {
+	if n := len(e.program.Motor); n > 0 && e.program.Motor[n-1].Beat == change.Beat {
+		e.program.Motor[n-1] = change
+		return
+	}
+	e.program.Motor = append(e.program.Motor, change)
+}
@@ -0,0 +1,9 @@
+package automation
+
+// FaderChange moves the crossfader to Gain at Beat, where Beat is counted in
+// the same units as Move.Dt from the start of the program. A gain of 0 is a
+// closed (cut) fader and 1 is fully open.
+type FaderChange struct {
+	Beat float64
+	Gain float64
+}
@@ -0,0 +1,82 @@
+package automation
+
+const (
+	// nominalRpm is the platter speed at which a sample plays at its natural
+	// speed.
+	nominalRpm     = 100.0 / 3
+	defaultBrake   = 0.5
+	defaultStartup = 0.7
+	// releaseStep is the spacing in seconds of the keyframes generated while
+	// the record rides with the platter.
+	releaseStep = 0.01
+	// integrationStep is the time step used to integrate the platter speed.
+	integrationStep = 0.001
+)
+
+// MotorChange makes the platter speed go from whatever it is at Beat to
+// Speed, linearly over Ramp seconds. Speed is relative to the nominal speed
+// and is 0 when the motor is switched off.
+type MotorChange struct {
+	Beat  float64
+	Speed float64
+	Ramp  float64
+}
+
+// motorRamp is a MotorChange placed in time.
+type motorRamp struct {
+	start    float64
+	from     float64
+	to       float64
+	duration float64
+}
+
+func (r motorRamp) speedAt(t float64) float64 {
+	if t >= r.start+r.duration {
+		return r.to
+	}
+	return r.from + (r.to-r.from)*(t-r.start)/r.duration
+}
+
+// motorRamps returns the platter speed changes of the program in time order,
+// starting with the speed at time 0. Changes before the first move take
+// effect immediately.
+func (p *Program) motorRamps() []motorRamp {
+	ramps := []motorRamp{{start: 0, from: 1, to: 1}}
+	for _, change := range p.Motor {
+		if change.Beat == 0 {
+			ramps[0] = motorRamp{start: 0, from: change.Speed, to: change.Speed}
+			continue
+		}
+		start := p.BeatTime(change.Beat)
+		ramps = append(ramps, motorRamp{
+			start:    start,
+			from:     platterSpeedAt(ramps, start),
+			to:       change.Speed,
+			duration: change.Ramp,
+		})
+	}
+	return ramps
+}
+
+func platterSpeedAt(ramps []motorRamp, t float64) float64 {
+	current := ramps[0]
+	for _, ramp := range ramps[1:] {
+		if t < ramp.start {
+			break
+		}
+		current = ramp
+	}
+	return current.speedAt(t)
+}
+
+// platterTravel returns how far in seconds the platter carries the head
+// between two times.
+func platterTravel(ramps []motorRamp, from, to float64) float64 {
+	travel := 0.0
+	for t := from; t < to; {
+		next := min(t+integrationStep, to)
+		travel += (platterSpeedAt(ramps, t) + platterSpeedAt(ramps, next)) / 2 * (next - t)
+		t = next
+	}
+	return travel
+}
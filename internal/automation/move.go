@@ -1,6 +1,35 @@
 package automation
 
+// Move is a hand movement of the record: the head travels Dh beats in Dt
+// beats of time. A Release move lets go of the record for Dt beats instead,
+// and the head travels however far the platter carries it.
 type Move struct {
-	Dh float64
-	Dt float64
+	Dh      float64
+	Dt      float64
+	Release bool
+}
+
+// Beats returns the length of the moves of the program in beats.
+func (p *Program) Beats() float64 {
+	beats := 0.0
+	for _, move := range p.Moves {
+		beats += move.Dt
+	}
+	return beats
+}
+
+// MoveAt returns the index in Moves of the move played at the given beat, or
+// false before the first move and after the last one.
+func (p *Program) MoveAt(beat float64) (int, bool) {
+	if beat < 0 {
+		return 0, false
+	}
+	start := 0.0
+	for i, move := range p.Moves {
+		if beat < start+move.Dt {
+			return i, true
+		}
+		start += move.Dt
+	}
+	return 0, false
 }
@@ -1,14 +1,15 @@
 package automation
 
 import (
-	"bufio"
 	"fmt"
+	"math"
 	"regexp"
 	"strconv"
 	"strings"
 )
 
 var (
+	identifierRegex  = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_-]*$`)
 	fractionalRegex  = regexp.MustCompile(`^([+-]?\d+)/(\d+)`)
 	floatRegex       = regexp.MustCompile(`^([+-]?\d+\.\d+)$`)
 	intRegex         = regexp.MustCompile(`^([+-]?\d+)$`)
@@ -23,35 +24,123 @@ const (
 	actionTypeMove
 	actionTypeBpm
 	actionTypeInterpolation
+	actionTypeFader
+	actionTypeRepeat
+	actionTypePattern
+	actionTypeUse
+	actionTypePlatter
+	actionTypeRpm
+	actionTypeMotor
+	actionTypeBrake
+	actionTypeStartup
+	actionTypeBoundary
+	actionTypeCue
+	actionTypeRegion
 )
 
-type interpolationType string
+// InterpolationType is how the head moves between the keyframes of a
+// program.
+type InterpolationType string
 
 const (
-	interpolationTypeCubic  interpolationType = "cubic"
-	interpolationTypeLinear interpolationType = "linear"
+	InterpolationTypeCubic    InterpolationType = "cubic"
+	InterpolationTypeLinear   InterpolationType = "linear"
+	InterpolationTypeMonotone InterpolationType = "monotone"
+	InterpolationTypePhysical InterpolationType = "physical"
 )
 
 const (
 	bpmToken                 = "bpm"
+	rampToken                = "->"
+	overToken                = "over"
 	defaultBpm               = 140.0
 	equalToken               = "="
 	interpolateToken         = "interpolate"
-	defaultInterpolationType = interpolationTypeCubic
+	defaultInterpolationType = InterpolationTypeCubic
+	openToken                = "open"
+	cutToken                 = "cut"
+	faderToken               = "fader"
+	faderGainOpen            = 1.0
+	faderGainCut             = 0.0
+	repeatToken              = "repeat"
+	patternToken             = "pattern"
+	useToken                 = "use"
+	blockStartToken          = "{"
+	blockEndToken            = "}"
+	platterToken             = "platter"
+	platterMassToken         = "mass"
+	platterTorqueToken       = "torque"
+	platterSlipToken         = "slip"
+	rpmToken                 = "rpm"
+	motorToken               = "motor"
+	motorOnToken             = "on"
+	motorOffToken            = "off"
+	brakeToken               = "brake"
+	startupToken             = "startup"
+	releaseToken             = "release"
+	boundaryToken            = "boundary"
+	cueToken                 = "cue"
+	regionToken              = "region"
+	secondsSuffix            = "s"
+	commentToken             = "#"
+	quoteToken               = `"`
+)
+
+const (
+	moveHint        = `a move is "<dh> [<dt>|=]", e.g. "+1/2 1/4"`
+	bpmHint         = `expected "bpm <tempo>" or "bpm <tempo> -> <tempo> over <beats>"`
+	interpolateHint = `expected "interpolate cubic", "interpolate linear", "interpolate monotone" or "interpolate physical"`
+	faderHint       = `expected "open", "cut" or "fader <gain>" with a gain between 0 and 1`
+	repeatHint      = `expected "repeat <count> {" with a positive count`
+	patternHint     = `expected "pattern <name> {"`
+	useHint         = `expected "use <name>"`
+	platterHint     = `expected "platter mass <mass>", "platter torque <torque>" or "platter slip <0..1>"`
+	rpmHint         = `expected "rpm <speed>", e.g. "rpm 45"`
+	motorHint       = `expected "motor on" or "motor off"`
+	brakeHint       = `expected "brake <seconds>"`
+	startupHint     = `expected "startup <seconds>"`
+	releaseHint     = `expected "release" or "release <dt>"`
+	boundaryHint    = `expected "boundary wrap", "boundary clamp", "boundary silence" or "boundary pingpong"`
+	cueHint         = `expected "cue <position>" with a position in beats ("3/4"), seconds ("1.25s") or a marker ("\"hit\"")`
+	regionHint      = `expected "region <start> <end>" with positions in beats ("3/4"), seconds ("1.25s") or markers, or "region <marker>"`
+	lineHint        = `expected a move or one of bpm, interpolate, platter, rpm, motor, brake, startup, release, boundary, cue, region, open, cut, fader, repeat, pattern, use`
 )
 
 type action struct {
 	actionType        actionType
 	bpm               float64
+	bpmTarget         float64
+	bpmRamp           float64
 	move              *Move
-	interpolationType interpolationType
+	interpolationType InterpolationType
+	faderGain         float64
+	platterParam      string
+	platterValue      float64
+	rpm               float64
+	motorOn           bool
+	seconds           float64
+	boundary          string
+	positions         []SamplePosition
+	count             int
+	name              string
+	body              []statement
+}
+
+// statement is an action together with the line and tokens it was parsed
+// from, so errors found while expanding blocks can point back to it.
+type statement struct {
+	line   int
+	tokens []Token
+	action *action
 }
 
+// parseReal parses a number written as an integer, a fraction or a decimal,
+// rejecting numbers that are not finite, such as 1/0.
 func parseReal(field string) (float64, bool) {
 	if matches := fractionalRegex.FindStringSubmatch(field); len(matches) == 3 {
 		num, _ := strconv.Atoi(matches[1])
 		den, _ := strconv.Atoi(matches[2])
-		return float64(num) / float64(den), true
+		return finite(float64(num) / float64(den))
 	}
 
 	if matches := intRegex.FindStringSubmatch(field); len(matches) == 2 {
@@ -61,7 +150,7 @@ func parseReal(field string) (float64, bool) {
 
 	if matches := floatRegex.FindStringSubmatch(field); len(matches) == 2 {
 		num, _ := strconv.ParseFloat(matches[1], 64)
-		return num, true
+		return finite(num)
 	}
 
 	if matches := singlePlusRegex.FindStringSubmatch(field); len(matches) == 1 {
@@ -75,17 +164,53 @@ func parseReal(field string) (float64, bool) {
 	return 0.0, false
 }
 
-func parseMove(fields []string) (*Move, bool) {
-	if len(fields) == 0 {
-		return nil, false
+// finite returns a number parsed and whether it is finite.
+func finite(num float64) (float64, bool) {
+	return num, !math.IsInf(num, 0) && !math.IsNaN(num)
+}
+
+// parsePosition parses a sample position: a number of beats, a number of
+// seconds when suffixed with "s", or the quoted name of a marker.
+func parsePosition(field string) (SamplePosition, bool) {
+	if strings.HasPrefix(field, quoteToken) {
+		name, ok := parseMarkerName(field)
+		return SamplePosition{Marker: name}, ok
+	}
+
+	if value, ok := strings.CutSuffix(field, secondsSuffix); ok {
+		seconds, ok := parseReal(value)
+		if !ok || seconds < 0 {
+			return SamplePosition{}, false
+		}
+		return SamplePosition{Value: seconds}, true
+	}
+
+	beats, ok := parseReal(field)
+	if !ok || beats < 0 {
+		return SamplePosition{}, false
+	}
+	return SamplePosition{Value: beats, Beats: true}, true
+}
+
+func parseMarkerName(field string) (string, bool) {
+	name, err := strconv.Unquote(field)
+	if err != nil || name == "" {
+		return "", false
 	}
+	return name, true
+}
+
+func parseMove(fields []string) (*Move, *syntaxError) {
 	dh, ok := parseReal(fields[0])
 	if !ok {
-		return nil, false
+		return nil, &syntaxError{token: 0, message: "invalid head movement", hint: moveHint}
 	}
 
 	if len(fields) == 1 {
-		return &Move{Dh: dh, Dt: 1.0}, true
+		return &Move{Dh: dh, Dt: 1.0}, nil
+	}
+	if len(fields) > 2 {
+		return nil, &syntaxError{token: 2, message: "unexpected token", hint: moveHint}
 	}
 
 	// Handle equal sign: Dt = Abs(Dh)
@@ -94,134 +219,570 @@ func parseMove(fields []string) (*Move, bool) {
 		if dt < 0 {
 			dt = -dt
 		}
-		return &Move{Dh: dh, Dt: dt}, true
+		return &Move{Dh: dh, Dt: dt}, nil
 	}
 
 	dt, ok := parseReal(fields[1])
 	if !ok {
-		return nil, false
+		return nil, &syntaxError{token: 1, message: "invalid duration", hint: moveHint}
 	}
-	return &Move{Dt: dt, Dh: dh}, true
+	return &Move{Dt: dt, Dh: dh}, nil
 }
 
-func parseBpm(fields []string) (float64, bool) {
+// parseBpm parses either a plain tempo ("bpm 120") or a ramp
+// ("bpm 120 -> 160 over 8"), returning the start tempo, the target tempo and
+// the ramp length in beats.
+func parseBpm(fields []string) (float64, float64, float64, *syntaxError) {
 	if len(fields) < 2 {
-		return 0.0, false
+		return 0.0, 0.0, 0.0, &syntaxError{token: 1, message: "missing tempo", hint: bpmHint}
+	}
+	bpm, ok := parseReal(fields[1])
+	if !ok || bpm <= 0 {
+		return 0.0, 0.0, 0.0, &syntaxError{token: 1, message: "invalid tempo", hint: bpmHint}
 	}
-	if fields[0] != bpmToken {
-		return 0.0, false
+	if len(fields) == 2 {
+		return bpm, bpm, 0.0, nil
+	}
+
+	if fields[2] != rampToken {
+		return 0.0, 0.0, 0.0, &syntaxError{token: 2, message: "unexpected token", hint: bpmHint}
 	}
-	return parseReal(fields[1])
+	if len(fields) < 4 {
+		return 0.0, 0.0, 0.0, &syntaxError{token: 3, message: "missing target tempo", hint: bpmHint}
+	}
+	target, ok := parseReal(fields[3])
+	if !ok || target <= 0 {
+		return 0.0, 0.0, 0.0, &syntaxError{token: 3, message: "invalid target tempo", hint: bpmHint}
+	}
+	if len(fields) < 5 || fields[4] != overToken {
+		return 0.0, 0.0, 0.0, &syntaxError{token: 4, message: "missing ramp length", hint: bpmHint}
+	}
+	if len(fields) < 6 {
+		return 0.0, 0.0, 0.0, &syntaxError{token: 5, message: "missing ramp length", hint: bpmHint}
+	}
+	ramp, ok := parseReal(fields[5])
+	if !ok || ramp < 0 {
+		return 0.0, 0.0, 0.0, &syntaxError{token: 5, message: "invalid ramp length", hint: bpmHint}
+	}
+	if len(fields) > 6 {
+		return 0.0, 0.0, 0.0, &syntaxError{token: 6, message: "unexpected token", hint: bpmHint}
+	}
+	return bpm, target, ramp, nil
 }
 
-func parseInterpolation(fields []string) (interpolationType, bool) {
+func parseInterpolation(fields []string) (InterpolationType, *syntaxError) {
 	if len(fields) < 2 {
-		return "", false
+		return "", &syntaxError{token: 1, message: "missing interpolation type", hint: interpolateHint}
 	}
-	if fields[0] != interpolateToken {
-		return "", false
+	if len(fields) > 2 {
+		return "", &syntaxError{token: 2, message: "unexpected token", hint: interpolateHint}
+	}
+	if !isValidInterpolationType(InterpolationType(fields[1])) {
+		return "", &syntaxError{token: 1, message: "invalid interpolation type", hint: interpolateHint}
+	}
+	return InterpolationType(fields[1]), nil
+}
+
+func parsePlatter(fields []string) (string, float64, *syntaxError) {
+	if len(fields) < 2 {
+		return "", 0.0, &syntaxError{token: 1, message: "missing platter parameter", hint: platterHint}
+	}
+	param := fields[1]
+	if param != platterMassToken && param != platterTorqueToken && param != platterSlipToken {
+		return "", 0.0, &syntaxError{token: 1, message: "unknown platter parameter", hint: platterHint}
+	}
+	if len(fields) < 3 {
+		return "", 0.0, &syntaxError{token: 2, message: "missing platter " + param, hint: platterHint}
+	}
+	if len(fields) > 3 {
+		return "", 0.0, &syntaxError{token: 3, message: "unexpected token", hint: platterHint}
+	}
+	value, ok := parseReal(fields[2])
+	if !ok || !isValidPlatterParam(param, value) {
+		return "", 0.0, &syntaxError{token: 2, message: "invalid platter " + param, hint: platterHint}
+	}
+	return param, value, nil
+}
+
+// parseRpm parses a platter speed. As on the speed selector of a turntable
+// "33" stands for 33⅓.
+func parseRpm(fields []string) (float64, *syntaxError) {
+	if len(fields) < 2 {
+		return 0.0, &syntaxError{token: 1, message: "missing rpm", hint: rpmHint}
+	}
+	if len(fields) > 2 {
+		return 0.0, &syntaxError{token: 2, message: "unexpected token", hint: rpmHint}
+	}
+	rpm, ok := parseReal(fields[1])
+	if !ok || rpm <= 0 {
+		return 0.0, &syntaxError{token: 1, message: "invalid rpm", hint: rpmHint}
+	}
+	if rpm == 33 {
+		rpm = nominalRpm
+	}
+	return rpm, nil
+}
+
+func parseMotor(fields []string) (bool, *syntaxError) {
+	if len(fields) < 2 {
+		return false, &syntaxError{token: 1, message: "missing motor state", hint: motorHint}
+	}
+	if len(fields) > 2 {
+		return false, &syntaxError{token: 2, message: "unexpected token", hint: motorHint}
+	}
+	switch fields[1] {
+	case motorOnToken:
+		return true, nil
+	case motorOffToken:
+		return false, nil
+	}
+	return false, &syntaxError{token: 1, message: "invalid motor state", hint: motorHint}
+}
+
+// parseSeconds parses the duration argument of brake and startup.
+func parseSeconds(fields []string, hint string) (float64, *syntaxError) {
+	if len(fields) < 2 {
+		return 0.0, &syntaxError{token: 1, message: "missing duration", hint: hint}
+	}
+	if len(fields) > 2 {
+		return 0.0, &syntaxError{token: 2, message: "unexpected token", hint: hint}
+	}
+	seconds, ok := parseReal(fields[1])
+	if !ok || seconds < 0 {
+		return 0.0, &syntaxError{token: 1, message: "invalid duration", hint: hint}
+	}
+	return seconds, nil
+}
+
+func parseRelease(fields []string) (*Move, *syntaxError) {
+	if len(fields) == 1 {
+		return &Move{Dt: 1.0, Release: true}, nil
+	}
+	if len(fields) > 2 {
+		return nil, &syntaxError{token: 2, message: "unexpected token", hint: releaseHint}
+	}
+	dt, ok := parseReal(fields[1])
+	if !ok || dt < 0 {
+		return nil, &syntaxError{token: 1, message: "invalid duration", hint: releaseHint}
+	}
+	return &Move{Dt: dt, Release: true}, nil
+}
+
+func parseBoundary(fields []string) (string, *syntaxError) {
+	if len(fields) < 2 {
+		return "", &syntaxError{token: 1, message: "missing boundary mode", hint: boundaryHint}
+	}
+	if len(fields) > 2 {
+		return "", &syntaxError{token: 2, message: "unexpected token", hint: boundaryHint}
+	}
+	if !isValidBoundary(fields[1]) {
+		return "", &syntaxError{token: 1, message: "invalid boundary mode", hint: boundaryHint}
+	}
+	return fields[1], nil
+}
+
+func parseCue(fields []string) (SamplePosition, *syntaxError) {
+	if len(fields) < 2 {
+		return SamplePosition{}, &syntaxError{token: 1, message: "missing cue position", hint: cueHint}
+	}
+	if len(fields) > 2 {
+		return SamplePosition{}, &syntaxError{token: 2, message: "unexpected token", hint: cueHint}
+	}
+	position, ok := parsePosition(fields[1])
+	if !ok {
+		return SamplePosition{}, &syntaxError{token: 1, message: "invalid cue position", hint: cueHint}
+	}
+	return position, nil
+}
+
+func parseRegion(fields []string) (*Region, *syntaxError) {
+	// A single marker gives both bounds
+	if len(fields) == 2 && strings.HasPrefix(fields[1], quoteToken) {
+		name, ok := parseMarkerName(fields[1])
+		if !ok {
+			return nil, &syntaxError{token: 1, message: "invalid marker name", hint: regionHint}
+		}
+		return &Region{
+			Start: SamplePosition{Marker: name},
+			End:   SamplePosition{Marker: name, MarkerEnd: true},
+		}, nil
+	}
+
+	region := &Region{}
+	for i, bound := range []*SamplePosition{&region.Start, &region.End} {
+		token := i + 1
+		if len(fields) <= token {
+			return nil, &syntaxError{token: token, message: "missing region bound", hint: regionHint}
+		}
+		position, ok := parsePosition(fields[token])
+		if !ok {
+			return nil, &syntaxError{token: token, message: "invalid region bound", hint: regionHint}
+		}
+		*bound = position
+	}
+	if len(fields) > 3 {
+		return nil, &syntaxError{token: 3, message: "unexpected token", hint: regionHint}
+	}
+	return region, nil
+}
+
+func parseFader(fields []string) (float64, *syntaxError) {
+	switch fields[0] {
+	case openToken, cutToken:
+		if len(fields) > 1 {
+			return 0.0, &syntaxError{token: 1, message: "unexpected token", hint: faderHint}
+		}
+		if fields[0] == openToken {
+			return faderGainOpen, nil
+		}
+		return faderGainCut, nil
+	}
+
+	if len(fields) < 2 {
+		return 0.0, &syntaxError{token: 1, message: "missing fader gain", hint: faderHint}
+	}
+	if len(fields) > 2 {
+		return 0.0, &syntaxError{token: 2, message: "unexpected token", hint: faderHint}
+	}
+	gain, ok := parseReal(fields[1])
+	if !ok || !isValidFaderGain(gain) {
+		return 0.0, &syntaxError{token: 1, message: "invalid fader gain", hint: faderHint}
+	}
+	return gain, nil
+}
+
+func parseRepeat(fields []string) (int, *syntaxError) {
+	if len(fields) < 2 {
+		return 0, &syntaxError{token: 1, message: "missing repeat count", hint: repeatHint}
+	}
+	matches := intRegex.FindStringSubmatch(fields[1])
+	if len(matches) != 2 {
+		return 0, &syntaxError{token: 1, message: "invalid repeat count", hint: repeatHint}
+	}
+	count, err := strconv.Atoi(matches[1])
+	if err != nil || count < 1 {
+		return 0, &syntaxError{token: 1, message: "invalid repeat count", hint: repeatHint}
+	}
+	if len(fields) < 3 || fields[2] != blockStartToken {
+		return 0, &syntaxError{token: 2, message: "missing " + strconv.Quote(blockStartToken), hint: repeatHint}
+	}
+	if len(fields) > 3 {
+		return 0, &syntaxError{token: 3, message: "unexpected token", hint: repeatHint}
+	}
+	return count, nil
+}
+
+func parsePattern(fields []string) (string, *syntaxError) {
+	if len(fields) < 2 {
+		return "", &syntaxError{token: 1, message: "missing pattern name", hint: patternHint}
+	}
+	if !identifierRegex.MatchString(fields[1]) {
+		return "", &syntaxError{token: 1, message: "invalid pattern name", hint: patternHint}
+	}
+	if len(fields) < 3 || fields[2] != blockStartToken {
+		return "", &syntaxError{token: 2, message: "missing " + strconv.Quote(blockStartToken), hint: patternHint}
+	}
+	if len(fields) > 3 {
+		return "", &syntaxError{token: 3, message: "unexpected token", hint: patternHint}
+	}
+	return fields[1], nil
+}
+
+func parseUse(fields []string) (string, *syntaxError) {
+	if len(fields) < 2 {
+		return "", &syntaxError{token: 1, message: "missing pattern name", hint: useHint}
 	}
-	return interpolationType(fields[1]), true
+	if !identifierRegex.MatchString(fields[1]) {
+		return "", &syntaxError{token: 1, message: "invalid pattern name", hint: useHint}
+	}
+	if len(fields) > 2 {
+		return "", &syntaxError{token: 2, message: "unexpected token", hint: useHint}
+	}
+	return fields[1], nil
 }
 
-func isValidInterpolationType(interpolationType interpolationType) bool {
-	return interpolationType == interpolationTypeCubic || interpolationType == interpolationTypeLinear
+func isValidPlatterParam(param string, value float64) bool {
+	switch param {
+	case platterMassToken:
+		return value > 0
+	case platterTorqueToken:
+		return value >= 0
+	case platterSlipToken:
+		return value >= 0 && value <= 1
+	}
+	return false
 }
 
-func parseLine(line string) (*action, error) {
-	if idx := strings.Index(line, "#"); idx != -1 {
-		line = line[:idx]
+// isValidBoundary checks a boundary mode name. The modes are those of the
+// ring, which resolves the name when it plays the program.
+func isValidBoundary(boundary string) bool {
+	switch boundary {
+	case "wrap", "clamp", "silence", "pingpong":
+		return true
 	}
+	return false
+}
 
-	fields := strings.Fields(line)
+func isValidFaderGain(gain float64) bool {
+	return gain >= faderGainCut && gain <= faderGainOpen
+}
+
+// ParseInterpolationType parses an interpolation type as used in session
+// files.
+func ParseInterpolationType(s string) (InterpolationType, error) {
+	if t := InterpolationType(s); isValidInterpolationType(t) {
+		return t, nil
+	}
+	return "", fmt.Errorf("invalid interpolation %q (expected %q, %q, %q or %q)", s,
+		InterpolationTypeCubic, InterpolationTypeLinear, InterpolationTypeMonotone, InterpolationTypePhysical)
+}
+
+func isValidInterpolationType(interpolationType InterpolationType) bool {
+	switch interpolationType {
+	case InterpolationTypeCubic, InterpolationTypeLinear, InterpolationTypeMonotone, InterpolationTypePhysical:
+		return true
+	}
+	return false
+}
+
+func parseLine(tokens []Token) (*action, *syntaxError) {
+	fields := make([]string, len(tokens))
+	for i, token := range tokens {
+		fields[i] = token.Text
+	}
 
 	if len(fields) == 0 {
 		return &action{actionType: actionTypeNone}, nil
 	}
 
-	if move, ok := parseMove(fields); ok {
+	if _, ok := parseReal(fields[0]); ok {
+		move, err := parseMove(fields)
+		if err != nil {
+			return nil, err
+		}
 		return &action{
 			actionType: actionTypeMove,
 			move:       move,
 		}, nil
 	}
 
-	if bpm, ok := parseBpm(fields); ok {
+	switch fields[0] {
+	case bpmToken:
+		bpm, target, ramp, err := parseBpm(fields)
+		if err != nil {
+			return nil, err
+		}
 		return &action{
 			actionType: actionTypeBpm,
 			bpm:        bpm,
+			bpmTarget:  target,
+			bpmRamp:    ramp,
 		}, nil
-	}
-
-	if interpolationType, ok := parseInterpolation(fields); ok {
+	case interpolateToken:
+		interpolationType, err := parseInterpolation(fields)
+		if err != nil {
+			return nil, err
+		}
 		return &action{
 			actionType:        actionTypeInterpolation,
 			interpolationType: interpolationType,
 		}, nil
+	case platterToken:
+		param, value, err := parsePlatter(fields)
+		if err != nil {
+			return nil, err
+		}
+		return &action{
+			actionType:   actionTypePlatter,
+			platterParam: param,
+			platterValue: value,
+		}, nil
+	case rpmToken:
+		rpm, err := parseRpm(fields)
+		if err != nil {
+			return nil, err
+		}
+		return &action{
+			actionType: actionTypeRpm,
+			rpm:        rpm,
+		}, nil
+	case motorToken:
+		motorOn, err := parseMotor(fields)
+		if err != nil {
+			return nil, err
+		}
+		return &action{
+			actionType: actionTypeMotor,
+			motorOn:    motorOn,
+		}, nil
+	case brakeToken:
+		seconds, err := parseSeconds(fields, brakeHint)
+		if err != nil {
+			return nil, err
+		}
+		return &action{
+			actionType: actionTypeBrake,
+			seconds:    seconds,
+		}, nil
+	case startupToken:
+		seconds, err := parseSeconds(fields, startupHint)
+		if err != nil {
+			return nil, err
+		}
+		return &action{
+			actionType: actionTypeStartup,
+			seconds:    seconds,
+		}, nil
+	case releaseToken:
+		move, err := parseRelease(fields)
+		if err != nil {
+			return nil, err
+		}
+		return &action{
+			actionType: actionTypeMove,
+			move:       move,
+		}, nil
+	case boundaryToken:
+		boundary, err := parseBoundary(fields)
+		if err != nil {
+			return nil, err
+		}
+		return &action{
+			actionType: actionTypeBoundary,
+			boundary:   boundary,
+		}, nil
+	case cueToken:
+		position, err := parseCue(fields)
+		if err != nil {
+			return nil, err
+		}
+		return &action{
+			actionType: actionTypeCue,
+			positions:  []SamplePosition{position},
+		}, nil
+	case regionToken:
+		region, err := parseRegion(fields)
+		if err != nil {
+			return nil, err
+		}
+		return &action{
+			actionType: actionTypeRegion,
+			positions:  []SamplePosition{region.Start, region.End},
+		}, nil
+	case openToken, cutToken, faderToken:
+		faderGain, err := parseFader(fields)
+		if err != nil {
+			return nil, err
+		}
+		return &action{
+			actionType: actionTypeFader,
+			faderGain:  faderGain,
+		}, nil
+	case repeatToken:
+		count, err := parseRepeat(fields)
+		if err != nil {
+			return nil, err
+		}
+		return &action{
+			actionType: actionTypeRepeat,
+			count:      count,
+		}, nil
+	case patternToken:
+		name, err := parsePattern(fields)
+		if err != nil {
+			return nil, err
+		}
+		return &action{
+			actionType: actionTypePattern,
+			name:       name,
+		}, nil
+	case useToken:
+		name, err := parseUse(fields)
+		if err != nil {
+			return nil, err
+		}
+		return &action{
+			actionType: actionTypeUse,
+			name:       name,
+		}, nil
 	}
 
-	return nil, fmt.Errorf("could not parse line %q", line)
+	return nil, &syntaxError{token: 0, message: "unknown command", hint: lineHint}
 }
 
-func Parse(input string) (*Program, error) {
-	program := &Program{
-		Bpm:   defaultBpm,
-		Moves: []Move{},
-	}
-	program.SetInterpolationType(defaultInterpolationType)
+// parseStatements turns the command and block nodes of a syntax tree into
+// statements. Nodes with errors are reported to errs and skipped.
+func parseStatements(nodes []*Node, nested bool, errs *errorCollector) []statement {
+	statements := []statement{}
+	for _, node := range nodes {
+		if node.Kind != CommandNode && node.Kind != BlockNode {
+			continue
+		}
 
-	bpmIsSet := false
-	interpolationIsSet := false
+		action, err := parseLine(node.Tokens)
+		if err != nil {
+			errs.add(node.Line, node.Tokens, err)
+			continue
+		}
 
-	scanner := bufio.NewScanner(strings.NewReader(input))
-	for scanner.Scan() {
-		line := scanner.Text()
-		line = strings.TrimSpace(line)
-		if line == "" {
+		if action.actionType == actionTypePattern && nested {
+			errs.add(node.Line, node.Tokens, &syntaxError{
+				token:   0,
+				message: "pattern must be defined at the top level",
+			})
 			continue
 		}
-		action, err := parseLine(line)
-		if err != nil {
-			return nil, err
-		}
-
-		switch action.actionType {
-		case actionTypeMove:
-			{
-				program.Moves = append(program.Moves, *action.move)
-			}
-		case actionTypeBpm:
-			{
-				if bpmIsSet {
-					return nil, fmt.Errorf("duplicate bpm set: %f", action.bpm)
-				}
-				program.Bpm = action.bpm
-				bpmIsSet = true
-			}
-		case actionTypeInterpolation:
-			{
-				if interpolationIsSet {
-					return nil, fmt.Errorf("duplicate interpolation set: %v", action.interpolationType)
-				}
-				if !isValidInterpolationType(action.interpolationType) {
-					return nil, fmt.Errorf("invalid interpolation type: %v", action.interpolationType)
-				}
-				program.SetInterpolationType(action.interpolationType)
-				interpolationIsSet = true
-			}
-		case actionTypeNone:
-			{
-				continue
-			}
-		default:
-			{
-				return nil, fmt.Errorf("unknown action type: %v", action.actionType)
-			}
-		}
-	}
-	if err := scanner.Err(); err != nil {
-		return nil, err
-	}
-
-	return program, nil
+		if node.Kind == BlockNode {
+			action.body = parseStatements(node.Body, true, errs)
+		}
+
+		statements = append(statements, statement{line: node.Line, tokens: node.Tokens, action: action})
+	}
+
+	return statements
+}
+
+// Parse parses an automation program. If the program has errors, the
+// returned error is a ParseErrors listing all of them.
+func Parse(input string) (*Program, error) {
+	return ParseFile("", input)
+}
+
+// ParseFile is like Parse but reports errors against the given file name.
+func ParseFile(fileName string, input string) (*Program, error) {
+	return ParseFileBpm(fileName, input, defaultBpm)
+}
+
+// ParseFileBpm is like ParseFile but starts the program at the given tempo
+// instead of the default one, until the program sets its own.
+func ParseFileBpm(fileName string, input string, bpm float64) (*Program, error) {
+	program, _, err := ParseFileLines(fileName, input, bpm)
+	return program, err
+}
+
+// ParseFileLines is like ParseFileBpm but also returns the line of the
+// source each move is written on, indexed like the moves of the program. A
+// bpm of 0 stands for the default tempo.
+func ParseFileLines(fileName string, input string, bpm float64) (*Program, []int, error) {
+	if bpm == 0 {
+		bpm = defaultBpm
+	}
+	errs := newErrorCollector(fileName)
+	file, err := parseAST(fileName, input, errs)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	statements := parseStatements(file.Nodes, false, errs)
+
+	e := newEvaluator(errs)
+	e.program.Bpm = bpm
+	e.run(statements)
+	e.finish()
+
+	if err := errs.err(); err != nil {
+		return nil, nil, err
+	}
+
+	return e.program, e.lines, nil
 }
@@ -1,9 +1,12 @@
 package automation_test
 
 import (
+	"math"
+	"strings"
 	"testing"
 
 	"github.com/fruity-loozrz/go-scratchpad/internal/automation"
+	kf "github.com/fruity-loozrz/go-scratchpad/internal/keyframes"
 
 	"github.com/stretchr/testify/require"
 )
@@ -31,7 +34,8 @@ func TestParserGreenPath(t *testing.T) {
 	require.NoError(err)
 	require.Equal(
 		&automation.Program{
-			Bpm: 120.0,
+			Bpm:       120.0,
+			Predictor: &kf.PiecewiseCubicPredictor{},
 			Moves: []automation.Move{
 				{Dh: 1, Dt: 1},
 				{Dh: -1, Dt: 1},
@@ -63,8 +67,9 @@ func TestParserDefaultBpm(t *testing.T) {
 	require.NoError(err)
 	require.Equal(
 		&automation.Program{
-			Bpm:   140,
-			Moves: []automation.Move{{Dh: 1, Dt: 1}},
+			Bpm:       140,
+			Predictor: &kf.PiecewiseCubicPredictor{},
+			Moves:     []automation.Move{{Dh: 1, Dt: 1}},
 		}, program)
 }
 
@@ -80,7 +85,8 @@ func TestParserWithComments(t *testing.T) {
 	require.NoError(err)
 	require.Equal(
 		&automation.Program{
-			Bpm: 140,
+			Bpm:       140,
+			Predictor: &kf.PiecewiseCubicPredictor{},
 			Moves: []automation.Move{
 				{Dh: 1, Dt: 1},
 				{Dh: 1, Dt: 1},
@@ -105,7 +111,8 @@ func TestParserWithEqualSign(t *testing.T) {
 	require.NoError(err)
 	require.Equal(
 		&automation.Program{
-			Bpm: 140,
+			Bpm:       140,
+			Predictor: &kf.PiecewiseCubicPredictor{},
 			Moves: []automation.Move{
 				{Dh: 0.5, Dt: 1},
 				{Dh: 0.5, Dt: 1},
@@ -117,3 +124,506 @@ func TestParserWithEqualSign(t *testing.T) {
 			},
 		}, program)
 }
+
+func TestParserFader(t *testing.T) {
+	program, err := automation.Parse(`
+cut
++1/2 1/4
+open
+-1/2 1/4
+fader 0.5
++ 1/2
+	`)
+	require := require.New(t)
+	require.NoError(err)
+	require.Equal(
+		&automation.Program{
+			Bpm:       140,
+			Predictor: &kf.PiecewiseCubicPredictor{},
+			Moves: []automation.Move{
+				{Dh: 0.5, Dt: 0.25},
+				{Dh: -0.5, Dt: 0.25},
+				{Dh: 1, Dt: 0.5},
+			},
+			Fader: []automation.FaderChange{
+				{Beat: 0, Gain: 0},
+				{Beat: 0.25, Gain: 1},
+				{Beat: 0.5, Gain: 0.5},
+			},
+		}, program)
+}
+
+func TestParserInvalidFaderGain(t *testing.T) {
+	_, err := automation.Parse("fader 2")
+	require := require.New(t)
+	require.Error(err)
+}
+
+func TestProgramFaderKeyframes(t *testing.T) {
+	program, err := automation.Parse(`
+bpm 60
+cut
++ 1
+open
++ 1
+	`)
+	require := require.New(t)
+	require.NoError(err)
+	require.Equal(
+		[]kf.Keyframe{
+			{Time: 0, Value: 0},
+			{Time: 1, Value: 0},
+			{Time: 1.002, Value: 1},
+			{Time: 2, Value: 1},
+		}, program.FaderKeyframes())
+}
+
+func TestParserRepeatAndPatterns(t *testing.T) {
+	program, err := automation.Parse(`
+pattern wah {
+	+2 1/3
+	-2 1/3
+}
+repeat 2 {
+	use wah
+	repeat 2 {
+		+
+	}
+}
+use wah
+	`)
+	require := require.New(t)
+	require.NoError(err)
+	require.Equal(
+		[]automation.Move{
+			{Dh: 2, Dt: 1.0 / 3},
+			{Dh: -2, Dt: 1.0 / 3},
+			{Dh: 1, Dt: 1},
+			{Dh: 1, Dt: 1},
+			{Dh: 2, Dt: 1.0 / 3},
+			{Dh: -2, Dt: 1.0 / 3},
+			{Dh: 1, Dt: 1},
+			{Dh: 1, Dt: 1},
+			{Dh: 2, Dt: 1.0 / 3},
+			{Dh: -2, Dt: 1.0 / 3},
+		}, program.Moves)
+}
+
+func TestParserPatternErrors(t *testing.T) {
+	for name, input := range map[string]string{
+		"undefined pattern": "use wah",
+		"unclosed block":    "repeat 2 {\n+",
+		"unexpected close":  "+\n}",
+		"nested pattern":    "repeat 2 {\npattern wah {\n+\n}\n}",
+		"duplicate pattern": "pattern wah {\n+\n}\npattern wah {\n-\n}",
+		"recursive pattern": "pattern wah {\nuse wah\n}\nuse wah",
+		"zero repeat count": "repeat 0 {\n+\n}",
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := automation.Parse(input)
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestParserTooManyMoves(t *testing.T) {
+	for name, test := range map[string]struct {
+		input string
+		line  int
+	}{
+		"nested repeats": {"+\nrepeat 1000 {\n\trepeat 1000000000 {\n\t\t+\n\t}\n}", 2},
+		"nested patterns": {`pattern a {
+	+
+	+
+	+
+	+
+	+
+	+
+	+
+	+
+	+
+	+
+}
+pattern b {
+	repeat 100 {
+		use a
+	}
+}
+pattern c {
+	repeat 100 {
+		use b
+	}
+}
++
+use c`, 24},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := automation.Parse(test.input)
+			var parseErrors automation.ParseErrors
+			require.ErrorAs(t, err, &parseErrors)
+			require.Len(t, parseErrors, 1)
+			require.Equal(t, test.line, parseErrors[0].Line)
+			require.Contains(t, parseErrors[0].Message, "moves")
+		})
+	}
+
+	// Up to the limit is fine
+	_, err := automation.Parse("repeat 1000 {\n\trepeat 100 {\n\t\t+\n\t}\n}")
+	require.NoError(t, err)
+}
+
+func TestParserErrorInPatternPointsToDefinition(t *testing.T) {
+	_, err := automation.Parse(`pattern wah {
+	+
+	use woo
+}
++
+use wah
+`)
+	require := require.New(t)
+	var parseErrors automation.ParseErrors
+	require.ErrorAs(err, &parseErrors)
+	require.Equal(
+		automation.ParseErrors{
+			{
+				Line:    3,
+				Column:  6,
+				Token:   "woo",
+				Message: "undefined pattern",
+				Hint:    `in pattern "wah" used at line 6`,
+			},
+		}, parseErrors)
+}
+
+func TestParserCollectsAllErrors(t *testing.T) {
+	_, err := automation.ParseFile("figure.auto.txt", `bpm 120
++1 foo
+fader 2
+  bar
++
+`)
+	require := require.New(t)
+	var parseErrors automation.ParseErrors
+	require.ErrorAs(err, &parseErrors)
+	require.Len(parseErrors, 3)
+	require.Equal(
+		&automation.ParseError{
+			FileName: "figure.auto.txt",
+			Line:     2,
+			Column:   4,
+			Token:    "foo",
+			Message:  "invalid duration",
+			Hint:     `a move is "<dh> [<dt>|=]", e.g. "+1/2 1/4"`,
+		}, parseErrors[0])
+	require.Equal(3, parseErrors[1].Line)
+	require.Equal("2", parseErrors[1].Token)
+	require.Equal(4, parseErrors[2].Line)
+	require.Equal(3, parseErrors[2].Column)
+	require.True(strings.HasPrefix(parseErrors[2].Error(), `figure.auto.txt:4:3: unknown command "bar" (expected a move`))
+}
+
+func TestParserTempoChanges(t *testing.T) {
+	program, err := automation.Parse(`
+bpm 120
++
+bpm 60
++
+bpm 60 -> 120 over 2
++ 2
+	`)
+	require := require.New(t)
+	require.NoError(err)
+	require.Equal(120.0, program.Bpm)
+	require.Equal(
+		[]automation.TempoChange{
+			{Beat: 1, Bpm: 60, TargetBpm: 60},
+			{Beat: 2, Bpm: 60, TargetBpm: 120, RampBeats: 2},
+		}, program.Tempo)
+	require.Equal(
+		[]kf.Keyframe{
+			{Time: 0.5, Value: 0.5},
+			{Time: 1.5, Value: 1.5},
+			{Time: 1.5 + 2*math.Ln2, Value: 1.5 + math.Ln2},
+		}, program.ToKeyframes())
+	require.InDelta(90.0, program.BpmAt(3), 1e-9)
+	for _, beat := range []float64{0, 0.5, 1.5, 3, 5} {
+		require.InDelta(beat, program.TimeBeat(program.BeatTime(beat)), 1e-9)
+	}
+}
+
+func TestProgramMoveAt(t *testing.T) {
+	program, err := automation.Parse("+1 1\n\n-1 2\nrelease 1/2")
+	require := require.New(t)
+	require.NoError(err)
+	require.Equal(3.5, program.Beats())
+
+	_, lines, err := automation.ParseFileLines("", "+1 1\n\n-1 2\nrelease 1/2", 0)
+	require.NoError(err)
+	require.Equal([]int{1, 3, 4}, lines)
+	for beat, index := range map[float64]int{0: 0, 0.99: 0, 1: 1, 2.5: 1, 3: 2} {
+		i, ok := program.MoveAt(beat)
+		require.True(ok, beat)
+		require.Equal(index, i, beat)
+	}
+	_, ok := program.MoveAt(-1)
+	require.False(ok)
+	_, ok = program.MoveAt(3.5)
+	require.False(ok)
+}
+
+func TestParserTempoRampAtStart(t *testing.T) {
+	program, err := automation.Parse(`
+bpm 120 -> 240 over 4
++ 4
++ 1
+	`)
+	require := require.New(t)
+	require.NoError(err)
+	require.Equal(120.0, program.Bpm)
+	require.InDelta(2*math.Ln2, program.BeatTime(4), 1e-9)
+	require.InDelta(2*math.Ln2+0.25, program.BeatTime(5), 1e-9)
+}
+
+func TestParserFileBpm(t *testing.T) {
+	require := require.New(t)
+
+	program, err := automation.ParseFileBpm("", "+1", 60)
+	require.NoError(err)
+	require.Equal(60.0, program.Bpm)
+	require.InDelta(1.0, program.BeatTime(1), 1e-9)
+
+	// The program's own tempo wins
+	program, err = automation.ParseFileBpm("", "bpm 120\n+1", 60)
+	require.NoError(err)
+	require.Equal(120.0, program.Bpm)
+}
+
+func TestParserInvalidBpm(t *testing.T) {
+	for _, input := range []string{"bpm 0", "bpm 120 -> 0 over 4", "bpm 120 -> 160", "bpm 120 -> 160 over"} {
+		_, err := automation.Parse(input)
+		require.Error(t, err, input)
+	}
+}
+
+func TestParserNonFiniteNumbers(t *testing.T) {
+	for input, token := range map[string]string{
+		"bpm 1/0":                               "1/0",
+		"bpm 120 -> 0/0 over 4":                 "0/0",
+		"bpm 120 -> 160 over 1/0":               "1/0",
+		"fader 0/0":                             "0/0",
+		"+1/0":                                  "+1/0",
+		"+1 " + strings.Repeat("9", 400) + ".0": strings.Repeat("9", 400) + ".0",
+	} {
+		_, err := automation.Parse(input)
+		var parseErrors automation.ParseErrors
+		require.ErrorAs(t, err, &parseErrors, input)
+		require.Len(t, parseErrors, 1, input)
+		require.Equal(t, token, parseErrors[0].Token, input)
+		require.Equal(t, strings.LastIndex(input, token)+1, parseErrors[0].Column, input)
+	}
+}
+
+func TestParserMonotoneInterpolation(t *testing.T) {
+	program, err := automation.Parse("interpolate monotone\n+")
+	require := require.New(t)
+	require.NoError(err)
+	require.Equal(&kf.MonotoneCubicPredictor{}, program.Predictor)
+}
+
+func TestParserPlatter(t *testing.T) {
+	program, err := automation.Parse(`
+interpolate physical
+platter mass 2
+platter slip 1/2
++
+	`)
+	require := require.New(t)
+	require.NoError(err)
+	params := kf.DefaultPlatterParams
+	params.Mass = 2
+	params.Slip = 0.5
+	require.Equal(kf.NewPlatterPredictor(params), program.Predictor)
+
+	_, err = automation.Parse("platter mass 2\n+")
+	require.Error(err)
+	_, err = automation.Parse("interpolate physical\nplatter slip 2\n+")
+	require.Error(err)
+}
+
+func TestParserMotor(t *testing.T) {
+	program, err := automation.Parse(`
+bpm 60
+rpm 45
+brake 1/2
++1 1
+release 1
+motor off
+rpm 33
+release
+motor on
+	`)
+	require := require.New(t)
+	require.NoError(err)
+	rpm45 := 45.0
+	require.Equal(
+		[]automation.Move{
+			{Dh: 1, Dt: 1},
+			{Dt: 1, Release: true},
+			{Dt: 1, Release: true},
+		}, program.Moves)
+	require.Equal(
+		[]automation.MotorChange{
+			{Beat: 0, Speed: rpm45 / (100.0 / 3), Ramp: 0.7},
+			{Beat: 2, Speed: 0, Ramp: 0.5},
+			{Beat: 3, Speed: 1, Ramp: 0.7},
+		}, program.Motor)
+
+	keyframes := program.ToKeyframes()
+	require.Equal(kf.Keyframe{Time: 1, Value: 1}, keyframes[0])
+
+	// Riding at 45 rpm for a beat, then braking to a stop in half a beat
+	afterRide := keyframes[len(keyframes)/2]
+	require.InDelta(2.0, afterRide.Time, 1e-9)
+	require.InDelta(1+1.35, afterRide.Value, 1e-6)
+	last := keyframes[len(keyframes)-1]
+	require.InDelta(3.0, last.Time, 1e-9)
+	require.InDelta(1+1.35+1.35*0.25, last.Value, 1e-6)
+}
+
+func TestParserPhysicalMotor(t *testing.T) {
+	program, err := automation.Parse(`
+interpolate physical
+bpm 60
+rpm 45
+0 1
+release 2
+	`)
+	require := require.New(t)
+	require.NoError(err)
+
+	// The released record rides at the speed of the motor, not the nominal
+	// one
+	keyframes := program.ToKeyframes()
+	predictor := program.Predictor.(*kf.PlatterPredictor)
+	require.Equal([]kf.PlatterRelease{{Start: 1, End: 3}}, predictor.Releases)
+	xs, ys := []float64{}, []float64{}
+	for _, keyframe := range keyframes {
+		xs = append(xs, keyframe.Time)
+		ys = append(ys, keyframe.Value)
+	}
+	require.NoError(predictor.Fit(xs, ys))
+	require.InDelta(1.35, (predictor.Predict(2.9)-predictor.Predict(2.8))/0.1, 0.01)
+}
+
+func TestParserPhysicalFit(t *testing.T) {
+	require := require.New(t)
+
+	// A light record is still simulated without diverging
+	program, err := automation.Parse("interpolate physical\nplatter mass 0.001\n0\n+1\n-1/2 1/2")
+	require.NoError(err)
+	sequence, err := kf.NewKeyframeSequence(program.Predictor, program.ToKeyframes())
+	require.NoError(err)
+	for x := 0.0; x < 1; x += 0.01 {
+		require.False(math.IsNaN(sequence.ValueAtTime(x)), x)
+	}
+
+	// A single move is an error, as with the other interpolations
+	program, err = automation.Parse("interpolate physical\n+1")
+	require.NoError(err)
+	_, err = kf.NewKeyframeSequence(program.Predictor, program.ToKeyframes())
+	require.Error(err)
+}
+
+func TestParserBoundary(t *testing.T) {
+	program, err := automation.Parse("boundary silence\n+")
+	require := require.New(t)
+	require.NoError(err)
+	require.Equal("silence", program.Boundary)
+
+	_, err = automation.Parse("boundary mirror\n+")
+	require.Error(err)
+	_, err = automation.Parse("boundary wrap\nboundary clamp\n+")
+	require.Error(err)
+}
+
+func TestParserCue(t *testing.T) {
+	program, err := automation.Parse("bpm 120\ncue 3/4\n1 1")
+	require := require.New(t)
+	require.NoError(err)
+	require.Equal(automation.SamplePosition{Value: 0.75, Beats: true}, program.Cue)
+	require.InDelta(0.375, program.CueTime(), 1e-9)
+	require.Equal([]kf.Keyframe{{Time: 0.5, Value: 0.875}}, program.ToKeyframes())
+
+	program, err = automation.Parse("cue 1.25s\n+")
+	require.NoError(err)
+	require.Equal(1.25, program.CueTime())
+
+	_, err = automation.Parse("+\ncue 1s")
+	require.Error(err)
+	_, err = automation.Parse("cue 1s\ncue 2s\n+")
+	require.Error(err)
+	_, err = automation.Parse("cue -1s\n+")
+	require.Error(err)
+}
+
+func TestParserRegion(t *testing.T) {
+	program, err := automation.Parse("+")
+	require := require.New(t)
+	require.NoError(err)
+	_, _, ok := program.RegionTimes()
+	require.False(ok)
+
+	program, err = automation.Parse("bpm 120\nregion 0.8s 4\n+")
+	require.NoError(err)
+	start, end, ok := program.RegionTimes()
+	require.True(ok)
+	require.Equal(0.8, start)
+	require.Equal(2.0, end)
+
+	_, err = automation.Parse("region 1.6s 0.8s\n+")
+	require.Error(err)
+	_, err = automation.Parse("region 0.8s\n+")
+	require.Error(err)
+	_, err = automation.Parse("region 0.8s 1.6s\nregion 0s 1s\n+")
+	require.Error(err)
+}
+
+func TestParserMarkers(t *testing.T) {
+	program, err := automation.Parse(`cue "hit # 2"
+region "loop"
++`)
+	require := require.New(t)
+	require.NoError(err)
+	require.Equal(automation.SamplePosition{Marker: "hit # 2"}, program.Cue)
+
+	markers := map[string][2]float64{
+		"hit # 2": {1.5, 1.5},
+		"loop":    {0.5, 2.5},
+	}
+	lookup := func(name string) (float64, float64, bool) {
+		marker, ok := markers[name]
+		return marker[0], marker[1], ok
+	}
+	require.NoError(program.ResolveMarkers(lookup))
+	require.Equal(1.5, program.CueTime())
+	start, end, ok := program.RegionTimes()
+	require.True(ok)
+	require.Equal(0.5, start)
+	require.Equal(2.5, end)
+
+	program, err = automation.Parse(`region "hit # 2" 2s` + "\n+")
+	require.NoError(err)
+	require.NoError(program.ResolveMarkers(lookup))
+	start, end, _ = program.RegionTimes()
+	require.Equal(1.5, start)
+	require.Equal(2.0, end)
+
+	program, err = automation.Parse(`cue "missing"` + "\n+")
+	require.NoError(err)
+	require.ErrorContains(program.ResolveMarkers(lookup), `unknown marker "missing"`)
+
+	_, err = automation.Parse(`cue ""` + "\n+")
+	require.Error(err)
+	_, err = automation.Parse(`cue "hit` + "\n+")
+	require.Error(err)
+}
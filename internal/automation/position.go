@@ -0,0 +1,74 @@
+package automation
+
+import "fmt"
+
+// SamplePosition is a point in the sample, given either in seconds, in beats
+// at the initial tempo of the program, or as the start (or the end, with
+// MarkerEnd) of a marker stored in the sample file.
+type SamplePosition struct {
+	Value     float64
+	Beats     bool
+	Marker    string
+	MarkerEnd bool
+}
+
+// Region bounds the part of the sample a program plays.
+type Region struct {
+	Start SamplePosition
+	End   SamplePosition
+}
+
+// PositionTime returns a sample position in seconds. Marker positions must
+// have been resolved with ResolveMarkers.
+func (p *Program) PositionTime(position SamplePosition) float64 {
+	if position.Beats {
+		return position.Value * 60.0 / p.Bpm
+	}
+	return position.Value
+}
+
+// CueTime returns the position of the head in the sample, in seconds, when
+// the program starts.
+func (p *Program) CueTime() float64 {
+	return p.PositionTime(p.Cue)
+}
+
+// RegionTimes returns the bounds of the region in seconds, or false if the
+// program plays the whole sample.
+func (p *Program) RegionTimes() (float64, float64, bool) {
+	if p.Region == nil {
+		return 0, 0, false
+	}
+	return p.PositionTime(p.Region.Start), p.PositionTime(p.Region.End), true
+}
+
+// ResolveMarkers replaces the positions given by marker names with the
+// positions of the markers returned by lookup, as start and end times in
+// seconds.
+func (p *Program) ResolveMarkers(lookup func(name string) (start, end float64, ok bool)) error {
+	positions := []*SamplePosition{&p.Cue}
+	if p.Region != nil {
+		positions = append(positions, &p.Region.Start, &p.Region.End)
+	}
+
+	for _, position := range positions {
+		if position.Marker == "" {
+			continue
+		}
+		start, end, ok := lookup(position.Marker)
+		if !ok {
+			return fmt.Errorf("unknown marker %q", position.Marker)
+		}
+		value := start
+		if position.MarkerEnd {
+			value = end
+		}
+		*position = SamplePosition{Value: value}
+	}
+
+	return nil
+}
+
+func (p SamplePosition) isMarker() bool {
+	return p.Marker != ""
+}
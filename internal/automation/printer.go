@@ -0,0 +1,79 @@
+package automation
+
+import (
+	"io"
+	"strings"
+)
+
+const indent = "\t"
+
+// Print writes the canonical text of a syntax tree: one command per line,
+// tokens separated by a single space and spelled as in the source, block
+// bodies indented with a tab, comments kept verbatim, runs of blank lines
+// collapsed to one and no blank lines at the start or end of the file or of
+// a block.
+func Print(w io.Writer, file *File) error {
+	var sb strings.Builder
+	printNodes(&sb, file.Nodes, 0)
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// Format parses an automation source and returns its canonical text.
+func Format(fileName string, input string) ([]byte, error) {
+	file, err := ParseAST(fileName, input)
+	if err != nil {
+		return nil, err
+	}
+
+	var sb strings.Builder
+	if err := Print(&sb, file); err != nil {
+		return nil, err
+	}
+	return []byte(sb.String()), nil
+}
+
+func printNodes(sb *strings.Builder, nodes []*Node, depth int) {
+	// Drop leading and trailing blank lines
+	for len(nodes) > 0 && nodes[0].Kind == BlankNode {
+		nodes = nodes[1:]
+	}
+	for len(nodes) > 0 && nodes[len(nodes)-1].Kind == BlankNode {
+		nodes = nodes[:len(nodes)-1]
+	}
+
+	for i, node := range nodes {
+		if node.Kind == BlankNode {
+			if nodes[i-1].Kind != BlankNode {
+				sb.WriteString("\n")
+			}
+			continue
+		}
+
+		sb.WriteString(strings.Repeat(indent, depth))
+		printLine(sb, node.Tokens, node.Comment)
+
+		if node.Kind == BlockNode {
+			printNodes(sb, node.Body, depth+1)
+			sb.WriteString(strings.Repeat(indent, depth))
+			printLine(sb, []Token{{Text: blockEndToken}}, node.EndComment)
+		}
+	}
+}
+
+func printLine(sb *strings.Builder, tokens []Token, comment string) {
+	for i, token := range tokens {
+		if i > 0 {
+			sb.WriteString(" ")
+		}
+		sb.WriteString(token.Text)
+	}
+	if comment != "" || len(tokens) == 0 {
+		if len(tokens) > 0 {
+			sb.WriteString(" ")
+		}
+		sb.WriteString(commentToken)
+		sb.WriteString(comment)
+	}
+	sb.WriteString("\n")
+}
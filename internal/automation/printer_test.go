@@ -0,0 +1,82 @@
+package automation_test
+
+import (
+	"testing"
+
+	"github.com/fruity-loozrz/go-scratchpad/internal/automation"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormatCanonical(t *testing.T) {
+	formatted, err := automation.Format("", `
+
+#header
+bpm   120  # tempo
+
+   pattern  wah {
++2   1/3
+
+-2 1/3
+}  # end of wah
+
+
+repeat 2 {
+  use wah
+	#inside
+}
+`)
+	require := require.New(t)
+	require.NoError(err)
+	require.Equal(`#header
+bpm 120 # tempo
+
+pattern wah {
+	+2 1/3
+
+	-2 1/3
+} # end of wah
+
+repeat 2 {
+	use wah
+	#inside
+}
+`, string(formatted))
+}
+
+func TestFormatIsIdempotentAndKeepsProgram(t *testing.T) {
+	source := `interpolate linear
+bpm 200 -> 220 over 4
+0.3 =
++ 3/4   # inline
+cut
+repeat 3 {
+-1/2 1/100
+}
+`
+	require := require.New(t)
+
+	formatted, err := automation.Format("", source)
+	require.NoError(err)
+	again, err := automation.Format("", string(formatted))
+	require.NoError(err)
+	require.Equal(string(formatted), string(again))
+
+	program, err := automation.Parse(source)
+	require.NoError(err)
+	formattedProgram, err := automation.Parse(string(formatted))
+	require.NoError(err)
+	require.Equal(program, formattedProgram)
+}
+
+func TestFormatUnclosedBlock(t *testing.T) {
+	_, err := automation.Format("", "repeat 2 {\n+")
+	require.Error(t, err)
+}
+
+func TestFormatQuotedMarker(t *testing.T) {
+	formatted, err := automation.Format("", "cue   \"hit  # \\\"2\\\"\"  # comment\n")
+	require := require.New(t)
+	require.NoError(err)
+	require.Equal("cue \"hit  # \\\"2\\\"\" # comment\n", string(formatted))
+}
@@ -1,30 +1,92 @@
 package automation
 
-import kf "github.com/fruity-loozrz/go-scratchpad/internal/keyframes"
+import (
+	"math"
+
+	kf "github.com/fruity-loozrz/go-scratchpad/internal/keyframes"
+)
+
+// faderRampDuration is the time in seconds the crossfader takes to travel
+// between two positions: short enough to sound like a cut, long enough not
+// to click.
+const faderRampDuration = 0.002
 
 type Program struct {
 	Bpm       float64
 	Predictor kf.PredictorFitter
 	Moves     []Move
+	Fader     []FaderChange
+	Tempo     []TempoChange
+	Motor     []MotorChange
+	// Boundary is the name of the ring boundary mode asked for by the
+	// program, if any.
+	Boundary string
+	// Cue is where the head starts in the sample.
+	Cue SamplePosition
+	// Region is the part of the sample the ring plays, or nil for the whole
+	// sample.
+	Region *Region
 }
 
-func (p *Program) SetInterpolationType(interpolationType interpolationType) {
+// SetInterpolationType sets how the head moves between keyframes. A
+// program already physical keeps its platter settings.
+func (p *Program) SetInterpolationType(interpolationType InterpolationType) {
+	if _, ok := p.Predictor.(*kf.PlatterPredictor); ok && interpolationType == InterpolationTypePhysical {
+		return
+	}
 	switch interpolationType {
-	case interpolationTypeCubic:
+	case InterpolationTypeCubic:
 		p.Predictor = &kf.PiecewiseCubicPredictor{}
-	case interpolationTypeLinear:
+	case InterpolationTypeLinear:
 		p.Predictor = &kf.PiecewiseLinearPredictor{}
+	case InterpolationTypeMonotone:
+		p.Predictor = &kf.MonotoneCubicPredictor{}
+	case InterpolationTypePhysical:
+		p.Predictor = kf.NewPlatterPredictor(kf.DefaultPlatterParams)
 	}
 }
 
+// ToKeyframes returns the head positions the moves of the program reach. A
+// physical predictor is also given the motor speed and the releases of the
+// program, which it needs to fit them.
 func (p *Program) ToKeyframes() []kf.Keyframe {
-	beatDuration := 60.0 / p.Bpm
+	beat := 0.0
 	realTime := 0.0
-	playHeadTime := 0.0
+	playHeadTime := p.CueTime()
+	motorRamps := p.motorRamps()
+	releases := []kf.PlatterRelease{}
 
 	keyframes := []kf.Keyframe{}
 	for _, move := range p.Moves {
-		realTime += move.Dt * beatDuration
+		if move.Release {
+			// Follow the platter closely enough for the interpolation to
+			// render its speed changes
+			beat += move.Dt
+			moveStart := realTime
+			moveEnd := p.BeatTime(beat)
+			releases = append(releases, kf.PlatterRelease{Start: moveStart, End: moveEnd})
+			steps := int(math.Ceil((moveEnd - moveStart) / releaseStep))
+			for i := 1; i <= steps; i++ {
+				next := moveStart + (moveEnd-moveStart)*float64(i)/float64(steps)
+				playHeadTime += platterTravel(motorRamps, realTime, next)
+				realTime = next
+				keyframes = append(keyframes, kf.Keyframe{
+					Time:  realTime,
+					Value: playHeadTime,
+				})
+			}
+			continue
+		}
+
+		// The head moves at Dh/Dt times the platter speed whatever the tempo,
+		// so Dh is scaled by the average beat duration over the move
+		beatDuration := 60.0 / p.BpmAt(beat)
+		beat += move.Dt
+		moveEnd := p.BeatTime(beat)
+		if move.Dt != 0 {
+			beatDuration = (moveEnd - realTime) / move.Dt
+		}
+		realTime = moveEnd
 		playHeadTime += move.Dh * beatDuration
 		keyframes = append(keyframes, kf.Keyframe{
 			Time:  realTime,
@@ -32,5 +94,46 @@ func (p *Program) ToKeyframes() []kf.Keyframe {
 		})
 	}
 
+	if platter, ok := p.Predictor.(*kf.PlatterPredictor); ok {
+		platter.Motor = func(t float64) float64 { return platterSpeedAt(motorRamps, t) }
+		platter.Releases = releases
+	}
+	return keyframes
+}
+
+// FaderKeyframes returns the crossfader gain envelope as keyframes meant for
+// linear interpolation. The fader starts open, and every change is turned
+// into a short ramp beginning at the change time. There are always at least
+// two keyframes, so the result can be fitted directly.
+func (p *Program) FaderKeyframes() []kf.Keyframe {
+	keyframes := []kf.Keyframe{{Time: 0, Value: faderGainOpen}}
+	for i, change := range p.Fader {
+		// Only the last of several changes at the same beat is audible
+		if i+1 < len(p.Fader) && p.Fader[i+1].Beat == change.Beat {
+			continue
+		}
+
+		last := keyframes[len(keyframes)-1]
+		if change.Beat == 0 {
+			keyframes[0].Value = change.Gain
+			continue
+		}
+		if change.Gain == last.Value {
+			continue
+		}
+
+		start := max(p.BeatTime(change.Beat), last.Time)
+		if start > last.Time {
+			keyframes = append(keyframes, kf.Keyframe{Time: start, Value: last.Value})
+		}
+		keyframes = append(keyframes, kf.Keyframe{Time: start + faderRampDuration, Value: change.Gain})
+	}
+
+	last := keyframes[len(keyframes)-1]
+	keyframes = append(keyframes, kf.Keyframe{
+		Time:  max(p.BeatTime(p.Beats()), last.Time+faderRampDuration),
+		Value: last.Value,
+	})
+
 	return keyframes
 }
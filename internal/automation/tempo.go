@@ -0,0 +1,112 @@
+package automation
+
+import "math"
+
+// TempoChange sets the tempo from Beat on. When RampBeats is positive the
+// tempo moves linearly (in bpm per beat) from Bpm to TargetBpm over RampBeats
+// beats and then holds TargetBpm; otherwise Bpm and TargetBpm are equal.
+type TempoChange struct {
+	Beat      float64
+	Bpm       float64
+	TargetBpm float64
+	RampBeats float64
+}
+
+// duration returns the time in seconds it takes to play the first beats of
+// the change.
+func (c TempoChange) duration(beats float64) float64 {
+	ramp := min(beats, c.RampBeats)
+	hold := beats - ramp
+
+	seconds := 60.0 * hold / c.TargetBpm
+	if ramp <= 0 {
+		return seconds
+	}
+	if c.TargetBpm == c.Bpm {
+		return seconds + 60.0*ramp/c.Bpm
+	}
+
+	// Integral of 60/bpm(b) db with bpm(b) = Bpm + slope*b
+	slope := (c.TargetBpm - c.Bpm) / c.RampBeats
+	return seconds + 60.0/slope*math.Log((c.Bpm+slope*ramp)/c.Bpm)
+}
+
+// bpmAt returns the tempo the given number of beats after the change.
+func (c TempoChange) bpmAt(beats float64) float64 {
+	if beats >= c.RampBeats {
+		return c.TargetBpm
+	}
+	return c.Bpm + (c.TargetBpm-c.Bpm)*beats/c.RampBeats
+}
+
+// tempoChanges returns the tempo map of the program, starting with the
+// initial tempo at beat 0.
+func (p *Program) tempoChanges() []TempoChange {
+	changes := []TempoChange{{Beat: 0, Bpm: p.Bpm, TargetBpm: p.Bpm}}
+	for _, change := range p.Tempo {
+		if change.Beat == 0 {
+			changes[0] = change
+			continue
+		}
+		changes = append(changes, change)
+	}
+	return changes
+}
+
+// BeatTime returns the time in seconds at which the given beat is reached,
+// following the tempo changes of the program.
+func (p *Program) BeatTime(beat float64) float64 {
+	changes := p.tempoChanges()
+	if beat <= 0 {
+		return beat * 60.0 / changes[0].Bpm
+	}
+
+	seconds := 0.0
+	for i, change := range changes {
+		if beat <= change.Beat {
+			break
+		}
+		end := beat
+		if i+1 < len(changes) {
+			end = min(end, changes[i+1].Beat)
+		}
+		seconds += change.duration(end - change.Beat)
+	}
+	return seconds
+}
+
+// TimeBeat returns the beat reached at the given time in seconds, the
+// inverse of BeatTime.
+func (p *Program) TimeBeat(seconds float64) float64 {
+	if seconds <= 0 {
+		return seconds * p.tempoChanges()[0].Bpm / 60.0
+	}
+
+	// BeatTime grows with the beat, so bisect it
+	low, high := 0.0, 1.0
+	for p.BeatTime(high) < seconds {
+		low, high = high, 2*high
+	}
+	for range 64 {
+		mid := (low + high) / 2
+		if p.BeatTime(mid) < seconds {
+			low = mid
+		} else {
+			high = mid
+		}
+	}
+	return (low + high) / 2
+}
+
+// BpmAt returns the tempo at the given beat.
+func (p *Program) BpmAt(beat float64) float64 {
+	changes := p.tempoChanges()
+	current := changes[0]
+	for _, change := range changes[1:] {
+		if beat < change.Beat {
+			break
+		}
+		current = change
+	}
+	return current.bpmAt(max(beat-current.Beat, 0))
+}
@@ -0,0 +1,165 @@
+// Package click synthesizes a metronome following the tempo of a routine.
+package click
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fruity-loozrz/go-scratchpad/internal/automation"
+	"github.com/fruity-loozrz/go-scratchpad/internal/ring"
+)
+
+var ErrInvalidBufferSize = errors.New("invalid buffer size")
+
+const (
+	// toneDuration is the length of a click in seconds, and toneDecay the
+	// time constant of its exponential decay.
+	toneDuration = 0.04
+	toneDecay    = 0.008
+	// Downbeats are higher and louder than the other beats.
+	accentFrequency = 1760
+	accentLevel     = 0.6
+	beatFrequency   = 880
+	beatLevel       = 0.4
+)
+
+// TimeSignature is the meter of the click: Beats clicks to the bar, each a
+// 1/Unit note. Beats of a routine are quarter notes.
+type TimeSignature struct {
+	Beats int
+	Unit  int
+}
+
+var DefaultTimeSignature = TimeSignature{Beats: 4, Unit: 4}
+
+func (s TimeSignature) String() string { return fmt.Sprintf("%d/%d", s.Beats, s.Unit) }
+
+// BarLength returns the length of a bar in beats of a routine.
+func (s TimeSignature) BarLength() float64 { return float64(s.Beats) * 4 / float64(s.Unit) }
+
+// Bar returns the beats of a routine the bar holding a beat starts and ends
+// at, counting bars from the start of the routine.
+func (s TimeSignature) Bar(beat float64) (from, to float64) {
+	length := s.BarLength()
+	from = math.Floor(max(beat, 0)/length) * length
+	return from, from + length
+}
+
+// ParseTimeSignature parses a time signature such as "4/4" or "6/8".
+func ParseTimeSignature(s string) (TimeSignature, error) {
+	beats, unit, ok := strings.Cut(s, "/")
+	signature := TimeSignature{}
+	var err1, err2 error
+	signature.Beats, err1 = strconv.Atoi(beats)
+	signature.Unit, err2 = strconv.Atoi(unit)
+	if !ok || err1 != nil || err2 != nil || signature.Beats < 1 || !validUnit(signature.Unit) {
+		return TimeSignature{}, fmt.Errorf("invalid time signature %q (expected beats/unit, e.g. 4/4 or 6/8)", s)
+	}
+	return signature, nil
+}
+
+func validUnit(unit int) bool {
+	switch unit {
+	case 1, 2, 4, 8, 16, 32:
+		return true
+	}
+	return false
+}
+
+// Track is a click on every beat of a routine, read as mono float32 LE
+// samples. Like a ring it plays from a start to an end time, at a speed.
+type Track struct {
+	sampleRate uint32
+	signature  TimeSignature
+	// ticks are the times of the clicks in seconds.
+	ticks []float64
+
+	start, end float64
+	time       float64
+	speed      float64
+}
+
+// NewTrack returns a click in the given time signature. It plays nothing
+// until it follows a program.
+func NewTrack(signature TimeSignature, sampleRate uint32) *Track {
+	return &Track{sampleRate: sampleRate, signature: signature, speed: 1, end: -1}
+}
+
+// Follow makes the click follow the tempo of a program between a start and
+// an end time, as played by a ring. It takes effect at the next reset.
+func (t *Track) Follow(program *automation.Program, start, end time.Duration) {
+	interval := 4 / float64(t.signature.Unit)
+	beats := program.Beats()
+	t.ticks = t.ticks[:0]
+	for i := 0; float64(i)*interval <= beats; i++ {
+		t.ticks = append(t.ticks, program.BeatTime(float64(i)*interval))
+	}
+	t.start, t.end = start.Seconds(), end.Seconds()
+}
+
+// SetSpeed sets how fast time runs, following a ring played at the same
+// speed. The pitch of the clicks does not change.
+func (t *Track) SetSpeed(speed float64) { t.speed = speed }
+
+// Reset moves playback back to the start.
+func (t *Track) Reset() { t.time = t.start }
+
+// SeekTime moves playback to a time after the start.
+func (t *Track) SeekTime(d time.Duration) error {
+	if d < 0 {
+		return fmt.Errorf("%w: negative time %s", ring.ErrInvalidSeek, d)
+	}
+	t.time = t.start + d.Seconds()
+	return nil
+}
+
+func (t *Track) Read(buf []byte) (int, error) {
+	if len(buf)%ring.SizeofFloat32 != 0 {
+		return 0, ErrInvalidBufferSize
+	}
+	if t.time > t.end {
+		return 0, io.EOF
+	}
+
+	// The click sounding is the last one before the time
+	next := sort.SearchFloat64s(t.ticks, t.time)
+	n := 0
+	for n < len(buf) {
+		for next < len(t.ticks) && t.ticks[next] <= t.time {
+			next++
+		}
+		binary.LittleEndian.PutUint32(buf[n:], math.Float32bits(float32(t.sample(next-1))))
+		n += ring.SizeofFloat32
+
+		t.time += t.speed / float64(t.sampleRate)
+		if t.time > t.end {
+			return n, io.EOF
+		}
+	}
+	return n, nil
+}
+
+// sample returns the sound of click i at the current time.
+func (t *Track) sample(i int) float64 {
+	if i < 0 {
+		return 0
+	}
+	// The click lasts as long at any speed
+	elapsed := (t.time - t.ticks[i]) / t.speed
+	if elapsed >= toneDuration {
+		return 0
+	}
+
+	frequency, level := float64(beatFrequency), beatLevel
+	if i%t.signature.Beats == 0 {
+		frequency, level = accentFrequency, accentLevel
+	}
+	return level * math.Exp(-elapsed/toneDecay) * math.Sin(2*math.Pi*frequency*elapsed)
+}
@@ -0,0 +1,105 @@
+package click_test
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/fruity-loozrz/go-scratchpad/internal/automation"
+	"github.com/fruity-loozrz/go-scratchpad/internal/click"
+
+	"github.com/stretchr/testify/require"
+)
+
+// peaks returns the loudest sample of each 1/10 second of a click at 8 kHz,
+// a beat at 600 bpm.
+func peaks(t *testing.T, track *click.Track) []float64 {
+	buf, err := io.ReadAll(track)
+	require.NoError(t, err)
+	peaks := []float64{}
+	for i := 0; i < len(buf); i += 4 {
+		if i/4%800 == 0 {
+			peaks = append(peaks, 0)
+		}
+		sample := math.Abs(float64(math.Float32frombits(binary.LittleEndian.Uint32(buf[i:]))))
+		peaks[len(peaks)-1] = math.Max(peaks[len(peaks)-1], sample)
+	}
+	return peaks
+}
+
+func TestTrack(t *testing.T) {
+	program, err := automation.Parse("bpm 600\n0\n+1\n+1\n+1\n+1\n+1")
+	require.NoError(t, err)
+
+	track := click.NewTrack(click.DefaultTimeSignature, 8000)
+	track.Follow(program, 0, 500*time.Millisecond)
+	track.Reset()
+	// Downbeats are louder; the last frame is where beat 5 ends
+	actual := peaks(t, track)
+	require.Len(t, actual, 6)
+	for i, peak := range actual[:5] {
+		if i%4 == 0 {
+			require.InDelta(t, 0.55, peak, 0.05, i)
+		} else {
+			require.InDelta(t, 0.37, peak, 0.05, i)
+		}
+	}
+
+	// A section plays the clicks of its own beats
+	track.Follow(program, 100*time.Millisecond, 300*time.Millisecond)
+	track.Reset()
+	actual = peaks(t, track)
+	require.Len(t, actual, 3)
+	require.InDelta(t, 0.37, actual[0], 0.05)
+	require.InDelta(t, 0.37, actual[1], 0.05)
+
+	require.NoError(t, track.SeekTime(150*time.Millisecond))
+	actual = peaks(t, track)
+	require.Len(t, actual, 1)
+	require.Zero(t, actual[0])
+	require.Error(t, track.SeekTime(-time.Second))
+
+	// Clicks fall on eighth notes in 6/8, two to a beat
+	track = click.NewTrack(click.TimeSignature{Beats: 6, Unit: 8}, 8000)
+	track.Follow(program, 0, 500*time.Millisecond)
+	track.Reset()
+	buf := make([]byte, 4*800)
+	_, err = io.ReadFull(track, buf)
+	require.NoError(t, err)
+	require.NotZero(t, binary.LittleEndian.Uint32(buf[4*401:]))
+}
+
+func TestBar(t *testing.T) {
+	for _, test := range []struct {
+		signature click.TimeSignature
+		beat      float64
+		from, to  float64
+	}{
+		{click.DefaultTimeSignature, 0, 0, 4},
+		{click.DefaultTimeSignature, 3.99, 0, 4},
+		{click.DefaultTimeSignature, 4, 4, 8},
+		{click.DefaultTimeSignature, 9.5, 8, 12},
+		{click.DefaultTimeSignature, -1, 0, 4},
+		{click.TimeSignature{Beats: 3, Unit: 4}, 7, 6, 9},
+		// Six eighth notes make three beats
+		{click.TimeSignature{Beats: 6, Unit: 8}, 4.5, 3, 6},
+		{click.TimeSignature{Beats: 2, Unit: 2}, 5, 4, 8},
+	} {
+		from, to := test.signature.Bar(test.beat)
+		require.Equal(t, [2]float64{test.from, test.to}, [2]float64{from, to}, "%s at beat %g", test.signature, test.beat)
+	}
+}
+
+func TestParseTimeSignature(t *testing.T) {
+	signature, err := click.ParseTimeSignature("7/8")
+	require.NoError(t, err)
+	require.Equal(t, click.TimeSignature{Beats: 7, Unit: 8}, signature)
+	require.Equal(t, "7/8", signature.String())
+
+	for _, s := range []string{"4", "0/4", "4/3", "x/4", "4/4/4"} {
+		_, err := click.ParseTimeSignature(s)
+		require.Error(t, err, s)
+	}
+}
@@ -61,7 +61,5 @@ func (k *KeyframeSequence) initialize() error {
 		values[i] = kf.Value
 	}
 
-	k.predictor.Fit(times, values)
-
-	return nil
+	return k.predictor.Fit(times, values)
 }
@@ -0,0 +1,86 @@
+package keyframes
+
+import (
+	"fmt"
+	"math"
+
+	"gonum.org/v1/gonum/interp"
+)
+
+// MonotoneCubicPredictor is a piecewise cubic Hermite interpolator with
+// derivatives chosen by the Fritsch–Carlson method, so the curve never
+// overshoots the keyframes: it is flat where neighbouring values are equal and
+// monotone between keyframes that are.
+type MonotoneCubicPredictor struct {
+	derivativePredictor interp.PiecewiseCubic
+}
+
+var _ interp.Predictor = (*MonotoneCubicPredictor)(nil)
+var _ interp.Fitter = (*MonotoneCubicPredictor)(nil)
+
+func (p *MonotoneCubicPredictor) Predict(t float64) float64 {
+	return p.derivativePredictor.Predict(t)
+}
+
+func (p *MonotoneCubicPredictor) Fit(xs, ys []float64) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("FitWithDerivatives panicked: %v", r)
+		}
+	}()
+
+	derivatives := p.computeDerivatives(xs, ys)
+	p.derivativePredictor.FitWithDerivatives(xs, ys, derivatives)
+
+	return nil
+}
+
+func (p *MonotoneCubicPredictor) computeDerivatives(xs, ys []float64) []float64 {
+	if len(xs) == 0 {
+		return []float64{}
+	}
+
+	if len(xs) == 1 {
+		return []float64{0}
+	}
+
+	n := len(xs)
+
+	// Secant slopes of each segment
+	slopes := make([]float64, n-1)
+	for i := range slopes {
+		slopes[i] = (ys[i+1] - ys[i]) / (xs[i+1] - xs[i])
+	}
+
+	derivatives := make([]float64, n)
+	derivatives[0] = slopes[0]
+	derivatives[n-1] = slopes[n-2]
+	for i := 1; i < n-1; i++ {
+		// Local extrema and changes of direction get a flat tangent
+		if slopes[i-1]*slopes[i] <= 0 {
+			derivatives[i] = 0
+		} else {
+			derivatives[i] = (slopes[i-1] + slopes[i]) / 2
+		}
+	}
+
+	for i, slope := range slopes {
+		if slope == 0 {
+			derivatives[i] = 0
+			derivatives[i+1] = 0
+			continue
+		}
+
+		// Keep (alpha, beta) inside the circle of radius 3, which is
+		// sufficient for the segment to be monotone
+		alpha := derivatives[i] / slope
+		beta := derivatives[i+1] / slope
+		if r := math.Hypot(alpha, beta); r > 3 {
+			tau := 3 / r
+			derivatives[i] = tau * alpha * slope
+			derivatives[i+1] = tau * beta * slope
+		}
+	}
+
+	return derivatives
+}
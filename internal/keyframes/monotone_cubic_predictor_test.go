@@ -0,0 +1,51 @@
+package keyframes_test
+
+import (
+	"testing"
+
+	"github.com/fruity-loozrz/go-scratchpad/internal/keyframes"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMonotoneCubicPredictorDoesNotOvershoot(t *testing.T) {
+	// +2 1/3, 0, -2 1/3: a push, a hold and a pull back
+	xs := []float64{0, 1.0 / 3, 4.0 / 3, 5.0 / 3}
+	ys := []float64{0, 2, 2, 0}
+
+	predictor := &keyframes.MonotoneCubicPredictor{}
+	require := require.New(t)
+	require.NoError(predictor.Fit(xs, ys))
+
+	for i := range xs {
+		require.InDelta(ys[i], predictor.Predict(xs[i]), 1e-12)
+	}
+
+	const steps = 1000
+	for i := 0; i <= steps; i++ {
+		x := xs[0] + (xs[len(xs)-1]-xs[0])*float64(i)/steps
+		y := predictor.Predict(x)
+		require.GreaterOrEqual(y, 0.0, "x=%f", x)
+		require.LessOrEqual(y, 2.0, "x=%f", x)
+		if x > xs[1] && x < xs[2] {
+			require.Equal(2.0, y, "hold must hold at x=%f", x)
+		}
+	}
+}
+
+func TestMonotoneCubicPredictorKeepsMonotoneData(t *testing.T) {
+	xs := []float64{0, 1, 1.1, 3, 3.2}
+	ys := []float64{0, 0.1, 2, 2.1, 5}
+
+	predictor := &keyframes.MonotoneCubicPredictor{}
+	require := require.New(t)
+	require.NoError(predictor.Fit(xs, ys))
+
+	previous := predictor.Predict(xs[0])
+	const steps = 1000
+	for i := 1; i <= steps; i++ {
+		y := predictor.Predict(xs[len(xs)-1] * float64(i) / steps)
+		require.GreaterOrEqual(y, previous)
+		previous = y
+	}
+}
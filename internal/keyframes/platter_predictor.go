@@ -0,0 +1,167 @@
+package keyframes
+
+import (
+	"fmt"
+	"math"
+
+	"gonum.org/v1/gonum/interp"
+)
+
+const (
+	// platterStep is the simulation time step in seconds.
+	platterStep = 1.0 / 2000
+	// handStiffness is the spring constant of the hand holding the record. With
+	// the default mass it tracks the hand target at about 25 Hz.
+	handStiffness = (2 * math.Pi * 25) * (2 * math.Pi * 25)
+	// motorResponse is the speed error at which the motor delivers its full
+	// torque.
+	motorResponse = 0.05
+	// maxSpringPhase is the largest angle the hand spring turns through in an
+	// integration step; light records are integrated in substeps to stay
+	// under it, as the integration diverges past 2.
+	maxSpringPhase = 0.5
+)
+
+// PlatterParams describes the physical turntable simulated by
+// PlatterPredictor.
+type PlatterParams struct {
+	// Mass is the inertia of the record and platter, in arbitrary units.
+	Mass float64
+	// Torque is the maximal force the motor applies to bring the record back
+	// to its nominal speed.
+	Torque float64
+	// Slip is the fraction of the motor torque lost in the slipmat, from 0
+	// (record glued to the platter) to 1 (record never dragged along).
+	Slip float64
+	// MotorSpeed is the speed the motor drives the record at, 1 being the
+	// nominal playback speed, unless the predictor has a Motor.
+	MotorSpeed float64
+}
+
+// PlatterRelease is a time span, in seconds, during which the hand is off
+// the record.
+type PlatterRelease struct {
+	Start, End float64
+}
+
+// DefaultPlatterParams are the parameters of a typical direct drive
+// turntable with a slipmat.
+var DefaultPlatterParams = PlatterParams{
+	Mass:       1.0,
+	Torque:     15.0,
+	Slip:       0.2,
+	MotorSpeed: 1.0,
+}
+
+// PlatterPredictor simulates the head position of a record with inertia,
+// driven by a motor through a slipmat and pushed around by a hand moving in
+// straight lines between the keyframes; the smoothing comes from the physics
+// rather than from the interpolation. During releases the hand is off the
+// record and lets it ride, and when it grabs the record again the moves
+// continue from wherever the record is.
+type PlatterPredictor struct {
+	Params PlatterParams
+	// Motor returns the speed the motor drives the record at, at a time. If
+	// nil, it drives the record at Params.MotorSpeed.
+	Motor func(t float64) float64
+	// Releases are the times the hand lets go of the record, in time order.
+	Releases []PlatterRelease
+
+	hand      PiecewiseLinearPredictor
+	start     float64
+	positions []float64
+}
+
+var _ interp.Predictor = (*PlatterPredictor)(nil)
+var _ interp.Fitter = (*PlatterPredictor)(nil)
+
+func NewPlatterPredictor(params PlatterParams) *PlatterPredictor {
+	return &PlatterPredictor{Params: params}
+}
+
+func (p *PlatterPredictor) Predict(t float64) float64 {
+	if len(p.positions) == 0 {
+		return 0
+	}
+
+	pos := (t - p.start) / platterStep
+	if pos <= 0 {
+		return p.positions[0]
+	}
+	i := int(pos)
+	if i >= len(p.positions)-1 {
+		return p.positions[len(p.positions)-1]
+	}
+	frac := pos - float64(i)
+	return p.positions[i] + (p.positions[i+1]-p.positions[i])*frac
+}
+
+func (p *PlatterPredictor) Fit(xs, ys []float64) error {
+	if p.Params.Mass <= 0 {
+		return fmt.Errorf("invalid platter mass: %f", p.Params.Mass)
+	}
+	if len(xs) < 2 {
+		p.positions = nil
+		return fmt.Errorf("too few keyframes for the platter: %d", len(xs))
+	}
+	if err := p.hand.Fit(xs, ys); err != nil {
+		return err
+	}
+
+	p.start = xs[0]
+	steps := int(math.Ceil((xs[len(xs)-1] - xs[0]) / platterStep))
+	p.positions = make([]float64, 0, steps+1)
+
+	mass := p.Params.Mass
+	damping := 2 * math.Sqrt(handStiffness*mass)
+	substeps := max(1, int(math.Ceil(platterStep*math.Sqrt(handStiffness/mass)/maxSpringPhase)))
+	dt := platterStep / float64(substeps)
+	motorTorque := p.Params.Torque * (1 - p.Params.Slip)
+
+	motorSpeed := p.Motor
+	if motorSpeed == nil {
+		motorSpeed = func(float64) float64 { return p.Params.MotorSpeed }
+	}
+
+	t := p.start
+	position := ys[0]
+	velocity := (p.hand.Predict(t+platterStep) - p.hand.Predict(t)) / platterStep
+	offset := 0.0
+	released := false
+	releases := p.Releases
+
+	for range steps + 1 {
+		p.positions = append(p.positions, position)
+
+		for range substeps {
+			target := p.hand.Predict(t)
+			targetVelocity := (p.hand.Predict(t+dt) - target) / dt
+
+			for len(releases) > 0 && releases[0].End <= t {
+				releases = releases[1:]
+			}
+			force := motorTorque * math.Tanh((motorSpeed(t)-velocity)/motorResponse)
+			if len(releases) > 0 && releases[0].Start <= t {
+				released = true
+			} else {
+				if released {
+					// The hand grabs the record where it is now
+					offset = position - target
+					released = false
+				}
+				force += handStiffness*(target+offset-position) + damping*(targetVelocity-velocity)
+			}
+
+			// Semi-implicit Euler keeps the stiff hand spring stable
+			velocity += force / mass * dt
+			position += velocity * dt
+			t += dt
+		}
+		if math.IsNaN(position) || math.IsInf(position, 0) {
+			p.positions = nil
+			return fmt.Errorf("platter simulation diverged at %.3fs", t)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,112 @@
+package keyframes_test
+
+import (
+	"testing"
+
+	"github.com/fruity-loozrz/go-scratchpad/internal/keyframes"
+
+	"github.com/stretchr/testify/require"
+)
+
+// speedStep is the time step the tests measure the record speed over.
+const speedStep = 0.001
+
+func speed(predictor *keyframes.PlatterPredictor, t float64) float64 {
+	return (predictor.Predict(t+speedStep) - predictor.Predict(t)) / speedStep
+}
+
+// spinUp fits a record held still for half a second, then released for two
+// seconds while the hand target keeps riding at the motor speed.
+func spinUp(t *testing.T, params keyframes.PlatterParams) *keyframes.PlatterPredictor {
+	predictor := keyframes.NewPlatterPredictor(params)
+	predictor.Releases = []keyframes.PlatterRelease{{Start: 0.5, End: 2.5}}
+	require.NoError(t, predictor.Fit([]float64{0, 0.5, 2.5}, []float64{0, 0, 2}))
+	return predictor
+}
+
+// riseTime returns how long after a release the record takes to reach 90%
+// of the motor speed.
+func riseTime(predictor *keyframes.PlatterPredictor) float64 {
+	for t := 0.5; t < 2.5; t += speedStep {
+		if speed(predictor, t) >= 0.9 {
+			return t - 0.5
+		}
+	}
+	return 2
+}
+
+func TestPlatterPredictorFollowsHand(t *testing.T) {
+	// +2 1/2, -1 1/2, held still
+	xs := []float64{0, 0.5, 1, 1.5}
+	ys := []float64{0, 1, 0.5, 0.5}
+	predictor := keyframes.NewPlatterPredictor(keyframes.DefaultPlatterParams)
+	require.NoError(t, predictor.Fit(xs, ys))
+
+	hand := &keyframes.PiecewiseLinearPredictor{}
+	require.NoError(t, hand.Fit(xs, ys))
+	for x := 0.0; x <= 1.5; x += 0.01 {
+		require.InDelta(t, hand.Predict(x), predictor.Predict(x), 0.05, "t=%f", x)
+	}
+	// The hand holds the record against the motor
+	require.InDelta(t, 0.5, predictor.Predict(1.5), 0.01)
+}
+
+func TestPlatterPredictorRelease(t *testing.T) {
+	predictor := spinUp(t, keyframes.DefaultPlatterParams)
+
+	// The motor drags the record up to its speed without a jump
+	require.InDelta(t, 0, speed(predictor, 0.4), 0.01)
+	previous := speed(predictor, 0.5)
+	for x := 0.5; x < 2.4; x += speedStep {
+		current := speed(predictor, x)
+		require.GreaterOrEqual(t, current, previous-1e-3, "t=%f", x)
+		require.Less(t, current-previous, 0.05, "t=%f", x)
+		previous = current
+	}
+	require.InDelta(t, 1, speed(predictor, 2.4), 0.01)
+	require.Greater(t, riseTime(predictor), 0.05)
+}
+
+func TestPlatterPredictorMass(t *testing.T) {
+	heavy := keyframes.DefaultPlatterParams
+	heavy.Mass *= 2
+	light := riseTime(spinUp(t, keyframes.DefaultPlatterParams))
+	require.Greater(t, riseTime(spinUp(t, heavy)), 1.5*light)
+}
+
+func TestPlatterPredictorMotor(t *testing.T) {
+	// The record only rides at the speed of the motor
+	predictor := keyframes.NewPlatterPredictor(keyframes.DefaultPlatterParams)
+	predictor.Motor = func(float64) float64 { return 0.5 }
+	predictor.Releases = []keyframes.PlatterRelease{{Start: 0.5, End: 2.5}}
+	require.NoError(t, predictor.Fit([]float64{0, 0.5, 2.5}, []float64{0, 0, 2}))
+	require.InDelta(t, 0.5, speed(predictor, 2.4), 0.01)
+
+	// Without a release the hand keeps hold of the record, even when it
+	// moves at the motor speed
+	predictor.Releases = nil
+	require.NoError(t, predictor.Fit([]float64{0, 0.5, 2.5}, []float64{0, 0, 2}))
+	require.InDelta(t, 1, speed(predictor, 2), 0.01)
+	require.InDelta(t, 0, speed(predictor, 0.4), 0.01)
+}
+
+func TestPlatterPredictorLightRecord(t *testing.T) {
+	// A very light record follows the hand closely rather than diverging
+	xs := []float64{0, 0.5, 1}
+	ys := []float64{0, 1, 0.5}
+	for _, mass := range []float64{0.001, 1e-6} {
+		params := keyframes.DefaultPlatterParams
+		params.Mass = mass
+		predictor := keyframes.NewPlatterPredictor(params)
+		require.NoError(t, predictor.Fit(xs, ys))
+		for x := 0.0; x <= 1; x += 0.01 {
+			require.InDelta(t, min(2*x, 1.5-x), predictor.Predict(x), 0.01, "mass %g at %f", mass, x)
+		}
+	}
+}
+
+func TestPlatterPredictorTooFewKeyframes(t *testing.T) {
+	predictor := keyframes.NewPlatterPredictor(keyframes.DefaultPlatterParams)
+	require.Error(t, predictor.Fit(nil, nil))
+	require.Error(t, predictor.Fit([]float64{1}, []float64{1}))
+}
@@ -0,0 +1,115 @@
+// Package mix maps and mixes audio channels.
+package mix
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+var ErrInvalidPan = errors.New("pan must be between -1 (left) and 1 (right)")
+
+// Matrix maps frames of one number of channels to another. Each output
+// channel is a weighted sum of the input channels.
+type Matrix struct {
+	in, out int
+	// gains holds the weight of input channel i in output channel o at
+	// gains[o*in+i].
+	gains []float32
+}
+
+// NewMatrix returns the mapping from in to out channels. Mono sources are
+// panned between -1 (left) and 1 (right) over the first two channels, front
+// left and right in every layout, leaving any others silent; pan is ignored
+// otherwise. Mappings without a sensible layout are errors.
+func NewMatrix(in, out int, pan float64) (*Matrix, error) {
+	if in <= 0 || out <= 0 {
+		return nil, fmt.Errorf("invalid channel mapping: %d to %d channels", in, out)
+	}
+	if pan < -1 || pan > 1 {
+		return nil, ErrInvalidPan
+	}
+
+	m := &Matrix{in: in, out: out, gains: make([]float32, in*out)}
+	const side = math.Sqrt2 / 2
+	switch {
+	case in == 1 && out >= 2:
+		l, r := Pan(pan)
+		m.set(0, 0, l)
+		m.set(1, 0, r)
+	case in == out:
+		for ch := range in {
+			m.set(ch, ch, 1)
+		}
+	case out == 1:
+		for ch := range in {
+			m.set(0, ch, 1/float64(in))
+		}
+	case in == 3 && out == 2:
+		// Left, right, center
+		m.set(0, 0, 1)
+		m.set(1, 1, 1)
+		m.set(0, 2, side)
+		m.set(1, 2, side)
+	case in == 4 && out == 2:
+		// Quad: front and back pairs
+		m.set(0, 0, 1)
+		m.set(1, 1, 1)
+		m.set(0, 2, side)
+		m.set(1, 3, side)
+	case in == 6 && out == 2:
+		// 5.1, dropping the low-frequency channel as the ITU downmix does
+		m.set(0, 0, 1)
+		m.set(1, 1, 1)
+		m.set(0, 2, side)
+		m.set(1, 2, side)
+		m.set(0, 4, side)
+		m.set(1, 5, side)
+	case in > 1 && out > in:
+		// Keep the layout of the source and leave the extra channels silent
+		for ch := range in {
+			m.set(ch, ch, 1)
+		}
+	default:
+		return nil, fmt.Errorf("cannot map %d channels to %d", in, out)
+	}
+	return m, nil
+}
+
+func (m *Matrix) set(out, in int, gain float64) { m.gains[out*m.in+in] = float32(gain) }
+
+// scale scales output channel out by gain.
+func (m *Matrix) scale(out int, gain float64) {
+	for i := range m.in {
+		m.gains[out*m.in+i] *= float32(gain)
+	}
+}
+
+// In returns the number of input channels.
+func (m *Matrix) In() int { return m.in }
+
+// Out returns the number of output channels.
+func (m *Matrix) Out() int { return m.out }
+
+// Apply maps the interleaved frames of src, appending them to dst. A
+// trailing partial frame is dropped.
+func (m *Matrix) Apply(dst, src []float32) []float32 {
+	for f := 0; f+m.in <= len(src); f += m.in {
+		frame := src[f : f+m.in]
+		for o := range m.out {
+			var sum float32
+			for i, gain := range m.gains[o*m.in : (o+1)*m.in] {
+				sum += gain * frame[i]
+			}
+			dst = append(dst, sum)
+		}
+	}
+	return dst
+}
+
+// Pan returns the left and right gains of a constant-power pan between -1
+// (left) and 1 (right).
+func Pan(pan float64) (left, right float64) {
+	angle := (pan + 1) * math.Pi / 4
+	return math.Cos(angle), math.Sin(angle)
+}
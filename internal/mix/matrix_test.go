@@ -0,0 +1,54 @@
+package mix_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/fruity-loozrz/go-scratchpad/internal/mix"
+
+	"github.com/stretchr/testify/require"
+)
+
+func apply(t *testing.T, in, out int, pan float64, src []float32) []float32 {
+	m, err := mix.NewMatrix(in, out, pan)
+	require.NoError(t, err)
+	return m.Apply(nil, src)
+}
+
+func TestMatrix(t *testing.T) {
+	require.Equal(t, []float32{1, 2, 3, 4}, apply(t, 2, 2, 0, []float32{1, 2, 3, 4}))
+	require.Equal(t, []float32{1.5, 3.5}, apply(t, 2, 1, 0, []float32{1, 2, 3, 4}))
+	require.Equal(t, []float32{1, 2, 0, 3, 4, 0}, apply(t, 2, 3, 0, []float32{1, 2, 3, 4}))
+	require.Equal(t, []float32{1, 0}, apply(t, 1, 2, -1, []float32{1}))
+
+	// Centered mono keeps its power
+	centered := apply(t, 1, 2, 0, []float32{1})
+	require.InDelta(t, math.Sqrt2/2, centered[0], 1e-6)
+	require.InDelta(t, math.Sqrt2/2, centered[1], 1e-6)
+
+	// Mono to 5.1 plays centered on the front pair
+	surround := apply(t, 1, 6, 0, []float32{1})
+	require.Len(t, surround, 6)
+	require.InDelta(t, math.Sqrt2/2, surround[0], 1e-6)
+	require.InDelta(t, math.Sqrt2/2, surround[1], 1e-6)
+	require.Equal(t, []float32{0, 0, 0, 0}, surround[2:])
+	require.Equal(t, []float32{1, 0, 0}, apply(t, 1, 3, -1, []float32{1}))
+
+	// 5.1 drops the low-frequency channel
+	surround = apply(t, 6, 2, 0, []float32{1, 0, 0, 1, 0, 0})
+	require.Equal(t, []float32{1, 0}, surround)
+
+	// A trailing partial frame is dropped
+	require.Equal(t, []float32{1, 2}, apply(t, 2, 2, 0, []float32{1, 2, 3}))
+}
+
+func TestMatrixErrors(t *testing.T) {
+	_, err := mix.NewMatrix(5, 2, 0)
+	require.Error(t, err)
+	_, err = mix.NewMatrix(5, 4, 0)
+	require.Error(t, err)
+	_, err = mix.NewMatrix(2, 0, 0)
+	require.Error(t, err)
+	_, err = mix.NewMatrix(1, 2, 2)
+	require.ErrorIs(t, err, mix.ErrInvalidPan)
+}
@@ -0,0 +1,151 @@
+package mix
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+const sizeofFloat32 = 4
+
+var ErrInvalidBufferSize = errors.New("invalid buffer size")
+
+// input is a source of float32 LE frames mixed into the output.
+type input struct {
+	r      io.Reader
+	matrix *Matrix
+	buf    []byte
+	done   bool
+}
+
+// Mixer sums several sources of interleaved float32 LE frames, all at the
+// same sample rate, into frames of one number of channels. It reads until
+// the longest source ends; shorter sources are followed by silence.
+type Mixer struct {
+	numChannels int
+	inputs      []*input
+	mixed       []float32
+	decoded     []float32
+	frames      []float32
+}
+
+// NewMixer returns a mixer with no sources producing frames of numChannels.
+func NewMixer(numChannels int) *Mixer {
+	return &Mixer{numChannels: numChannels}
+}
+
+// NumChannels returns the number of output channels.
+func (m *Mixer) NumChannels() int { return m.numChannels }
+
+// Add adds a source of frames of numChannels, scaled by gain and placed by
+// pan between -1 (left) and 1 (right). Mono sources are panned with constant
+// power over the front pair, others with a balance control; pan needs
+// stereo output for them unless it is 0.
+func (m *Mixer) Add(r io.Reader, numChannels int, gain, pan float64) error {
+	matrix, err := NewMatrix(numChannels, m.numChannels, pan)
+	if err != nil {
+		return err
+	}
+	switch {
+	case pan == 0 || numChannels == 1 && m.numChannels >= 2:
+	case m.numChannels == 2:
+		left, right := min(1, 1-pan), min(1, 1+pan)
+		matrix.scale(0, left)
+		matrix.scale(1, right)
+	default:
+		return fmt.Errorf("cannot pan to %d channels", m.numChannels)
+	}
+	for o := range m.numChannels {
+		matrix.scale(o, gain)
+	}
+
+	m.inputs = append(m.inputs, &input{r: r, matrix: matrix})
+	return nil
+}
+
+// Read reads mixed frames as float32 LE samples.
+func (m *Mixer) Read(buf []byte) (int, error) {
+	frameSize := m.numChannels * sizeofFloat32
+	if len(buf)%sizeofFloat32 != 0 || len(buf) < frameSize {
+		return 0, ErrInvalidBufferSize
+	}
+	numFrames := len(buf) / frameSize
+
+	m.mixed = m.mixed[:0]
+	for range numFrames * m.numChannels {
+		m.mixed = append(m.mixed, 0)
+	}
+	read := 0
+	for _, in := range m.inputs {
+		n, err := in.read(numFrames)
+		if err != nil {
+			return 0, err
+		}
+		m.decoded = decode(m.decoded[:0], in.buf[:n])
+		m.frames = in.matrix.Apply(m.frames[:0], m.decoded)
+		for i, sample := range m.frames {
+			m.mixed[i] += sample
+		}
+		read = max(read, len(m.frames)/m.numChannels)
+	}
+
+	for i, sample := range m.mixed[:read*m.numChannels] {
+		binary.LittleEndian.PutUint32(buf[i*sizeofFloat32:], math.Float32bits(sample))
+	}
+	if read < numFrames && m.done() {
+		return read * frameSize, io.EOF
+	}
+	return read * frameSize, nil
+}
+
+// Rewind makes the mixer read its sources again after they ended. The
+// sources must have been rewound themselves.
+func (m *Mixer) Rewind() {
+	for _, in := range m.inputs {
+		in.done = false
+	}
+}
+
+func (m *Mixer) done() bool {
+	for _, in := range m.inputs {
+		if !in.done {
+			return false
+		}
+	}
+	return true
+}
+
+// read reads up to numFrames whole frames into the buffer of the input,
+// returning the number of bytes read. Short reads are retried until the
+// source ends.
+func (in *input) read(numFrames int) (int, error) {
+	size := numFrames * in.matrix.in * sizeofFloat32
+	if cap(in.buf) < size {
+		in.buf = make([]byte, size)
+	}
+	in.buf = in.buf[:size]
+
+	n := 0
+	for n < size && !in.done {
+		m, err := in.r.Read(in.buf[n:])
+		n += m
+		if err == io.EOF {
+			in.done = true
+		} else if err != nil {
+			return 0, err
+		} else if m == 0 {
+			break
+		}
+	}
+	return n - n%(in.matrix.in*sizeofFloat32), nil
+}
+
+// decode appends float32 LE samples to dst.
+func decode(dst []float32, buf []byte) []float32 {
+	for i := 0; i+sizeofFloat32 <= len(buf); i += sizeofFloat32 {
+		dst = append(dst, math.Float32frombits(binary.LittleEndian.Uint32(buf[i:])))
+	}
+	return dst
+}
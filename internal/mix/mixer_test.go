@@ -0,0 +1,56 @@
+package mix_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"testing"
+
+	"github.com/fruity-loozrz/go-scratchpad/internal/mix"
+
+	"github.com/stretchr/testify/require"
+)
+
+func encode(samples ...float32) io.Reader {
+	buf := []byte{}
+	for _, sample := range samples {
+		buf = binary.LittleEndian.AppendUint32(buf, math.Float32bits(sample))
+	}
+	return bytes.NewReader(buf)
+}
+
+func readAll(t *testing.T, r io.Reader) []float32 {
+	buf, err := io.ReadAll(r)
+	require.NoError(t, err)
+	samples := []float32{}
+	for i := 0; i < len(buf); i += 4 {
+		samples = append(samples, math.Float32frombits(binary.LittleEndian.Uint32(buf[i:])))
+	}
+	return samples
+}
+
+func TestMixer(t *testing.T) {
+	mixer := mix.NewMixer(2)
+	// A mono deck hard left, then a longer stereo deck at half gain
+	require.NoError(t, mixer.Add(encode(1, 1), 1, 1, -1))
+	require.NoError(t, mixer.Add(encode(0.5, 1, 0.5, 1, 0.5, 1), 2, 0.5, 0))
+	require.Equal(t, []float32{1.25, 0.5, 1.25, 0.5, 0.25, 0.5}, readAll(t, mixer))
+
+	// Balance turns the far side down
+	mixer = mix.NewMixer(2)
+	require.NoError(t, mixer.Add(encode(1, 1), 2, 1, 0.5))
+	require.Equal(t, []float32{0.5, 1}, readAll(t, mixer))
+
+	// Mono pans over the front pair of 5.1
+	mixer = mix.NewMixer(6)
+	require.NoError(t, mixer.Add(encode(1), 1, 1, -1))
+	require.Equal(t, []float32{1, 0, 0, 0, 0, 0}, readAll(t, mixer))
+
+	mixer = mix.NewMixer(1)
+	require.Error(t, mixer.Add(encode(1), 1, 1, 0.5))
+	mixer = mix.NewMixer(6)
+	require.Error(t, mixer.Add(encode(1, 1), 2, 1, 0.5))
+	mixer = mix.NewMixer(2)
+	require.Error(t, mixer.Add(encode(1), 5, 1, 0))
+}
@@ -0,0 +1,232 @@
+package ring
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+)
+
+const (
+	formHeaderSize = 12
+	aiffCommSize   = 18
+	aiffMarkerSize = 6
+)
+
+// readIffChunks lists the top-level chunks of an IFF file, which are laid
+// out like those of a RIFF file with big endian sizes.
+func readIffChunks(r io.ReaderAt) ([]riffChunk, error) {
+	header := make([]byte, formHeaderSize)
+	if _, err := r.ReadAt(header, 0); err != nil {
+		return nil, err
+	}
+	if string(header[0:4]) != "FORM" {
+		return nil, fmt.Errorf("not an IFF file")
+	}
+
+	chunks := []riffChunk{}
+	offset := int64(formHeaderSize)
+	for {
+		if _, err := r.ReadAt(header[:chunkHeaderSize], offset); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return chunks, nil
+			}
+			return nil, err
+		}
+		chunk := riffChunk{
+			id:     string(header[0:4]),
+			offset: offset + chunkHeaderSize,
+			size:   binary.BigEndian.Uint32(header[4:8]),
+		}
+		chunks = append(chunks, chunk)
+		offset = chunk.offset + int64(chunk.size) + int64(chunk.size%2)
+	}
+}
+
+// openAiff opens an AIFF or AIFC file with the markers of its "MARK" chunk.
+// Frames are decoded on demand like those of WAV files.
+func openAiff(r io.ReaderAt) (Store, []Marker, error) {
+	chunks, err := readIffChunks(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	header := make([]byte, 4)
+	if _, err := r.ReadAt(header, formHeaderSize-4); err != nil {
+		return nil, nil, err
+	}
+	compressed := false
+	switch string(header) {
+	case "AIFF":
+	case "AIFC":
+		compressed = true
+	default:
+		return nil, nil, fmt.Errorf("not an AIFF file")
+	}
+
+	s := newPCMStore(r)
+	var frames uint32
+	var mark []byte
+	var formatFound, dataFound bool
+	for _, chunk := range chunks {
+		switch chunk.id {
+		case "COMM":
+			body, err := chunk.read(r)
+			if err != nil {
+				return nil, nil, err
+			}
+			if frames, err = readAiffFormat(s, body, compressed); err != nil {
+				return nil, nil, err
+			}
+			formatFound = true
+		case "SSND":
+			if chunk.size < 8 {
+				return nil, nil, fmt.Errorf("%q chunk: %w", chunk.id, errTruncatedChunk)
+			}
+			body := make([]byte, 4)
+			if _, err := r.ReadAt(body, chunk.offset); err != nil {
+				return nil, nil, fmt.Errorf("%q chunk: %w", chunk.id, errTruncatedChunk)
+			}
+			// Sound data starts after the offset and block size fields and
+			// the padding the offset gives
+			skip := int64(8) + int64(binary.BigEndian.Uint32(body))
+			s.dataOffset = chunk.offset + skip
+			s.frames = int(dataSize(r, s.dataOffset, int64(chunk.size)-skip))
+			dataFound = true
+		case "MARK":
+			if mark, err = chunk.read(r); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	if !formatFound {
+		return nil, nil, fmt.Errorf("common chunk not found")
+	}
+	if !dataFound {
+		return nil, nil, fmt.Errorf("sound data chunk not found")
+	}
+	// A streaming encoder may not know the number of frames
+	s.frames /= s.blockAlign()
+	if frames > 0 {
+		s.frames = min(s.frames, int(frames))
+	}
+	if s.frames == 0 {
+		return nil, nil, fmt.Errorf("%q chunk: %w", "SSND", ErrNoFrames)
+	}
+
+	markers, err := readAiffMarkers(mark, s.sampleRate)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to read markers: %w", err)
+	}
+
+	return s, markers, nil
+}
+
+func readAiffFormat(s *PCMStore, body []byte, compressed bool) (uint32, error) {
+	if len(body) < aiffCommSize || compressed && len(body) < aiffCommSize+4 {
+		return 0, fmt.Errorf("%q chunk: %w", "COMM", errTruncatedChunk)
+	}
+	s.numChannels = int(binary.BigEndian.Uint16(body[0:2]))
+	frames := binary.BigEndian.Uint32(body[2:6])
+	bits := int(binary.BigEndian.Uint16(body[6:8]))
+	s.sampleRate = uint32(math.Round(extendedFloat(body[8:18])))
+	if s.numChannels == 0 || s.sampleRate == 0 || bits == 0 {
+		return 0, fmt.Errorf("invalid format: %d channels at %d Hz", s.numChannels, s.sampleRate)
+	}
+
+	compression := "NONE"
+	if compressed {
+		compression = string(body[18:22])
+	}
+
+	s.width = (bits + 7) / 8
+	switch compression {
+	case "NONE", "twos":
+		if s.width > 4 {
+			return 0, fmt.Errorf("%w: %d bits per sample", ErrUnsupportedFormat, bits)
+		}
+		s.decode = decodeInt(s.width, binary.BigEndian)
+	case "sowt":
+		if s.width > 4 {
+			return 0, fmt.Errorf("%w: %d bits per sample", ErrUnsupportedFormat, bits)
+		}
+		s.decode = decodeInt(s.width, binary.LittleEndian)
+	case "in24":
+		s.width = 3
+		s.decode = decodeInt(s.width, binary.BigEndian)
+	case "in32":
+		s.width = 4
+		s.decode = decodeInt(s.width, binary.BigEndian)
+	case "raw ":
+		s.width = 1
+		s.decode = decodeUnsigned8
+	case "fl32", "FL32":
+		s.width = 4
+		s.decode = decodeFloat32(binary.BigEndian)
+	case "fl64", "FL64":
+		s.width = 8
+		s.decode = decodeFloat64(binary.BigEndian)
+	case "alaw", "ALAW":
+		s.width = 1
+		s.decode = decodeALaw
+	case "ulaw", "ULAW":
+		s.width = 1
+		s.decode = decodeMULaw
+	default:
+		return 0, fmt.Errorf("%w: compression %q", ErrUnsupportedFormat, compression)
+	}
+
+	return frames, nil
+}
+
+// extendedFloat decodes an 80-bit IEEE 754 extended precision number, which
+// AIFF uses for the sample rate.
+func extendedFloat(b []byte) float64 {
+	exponent := int(binary.BigEndian.Uint16(b[0:2]) & 0x7fff)
+	mantissa := binary.BigEndian.Uint64(b[2:10])
+	value := math.Ldexp(float64(mantissa), exponent-16383-63)
+	if b[0]&0x80 != 0 {
+		value = -value
+	}
+	return value
+}
+
+// readAiffMarkers reads the markers of a "MARK" chunk, named after their
+// name or their ID when they have none. AIFF markers are plain positions.
+func readAiffMarkers(mark []byte, sampleRate uint32) ([]Marker, error) {
+	markers := []Marker{}
+	if len(mark) == 0 {
+		return markers, nil
+	}
+	if len(mark) < 2 {
+		return nil, fmt.Errorf("%q chunk: %w", "MARK", errTruncatedChunk)
+	}
+
+	count := int(binary.BigEndian.Uint16(mark[0:2]))
+	pos := 2
+	for range count {
+		if pos+aiffMarkerSize+1 > len(mark) {
+			return nil, fmt.Errorf("%q chunk: %w", "MARK", errTruncatedChunk)
+		}
+		frame := binary.BigEndian.Uint32(mark[pos+2 : pos+6])
+		// The name is a Pascal string padded to an even size
+		length := int(mark[pos+aiffMarkerSize])
+		start := pos + aiffMarkerSize + 1
+		if start+length > len(mark) {
+			return nil, fmt.Errorf("%q chunk: %w", "MARK", errTruncatedChunk)
+		}
+		name := string(mark[start : start+length])
+		if name == "" {
+			name = strconv.Itoa(int(binary.BigEndian.Uint16(mark[pos : pos+2])))
+		}
+		pos = start + length
+		if (length+1)%2 == 1 {
+			pos++
+		}
+
+		seconds := float64(frame) / float64(sampleRate)
+		markers = append(markers, Marker{Name: name, Start: seconds, End: seconds})
+	}
+
+	return markers, nil
+}
@@ -0,0 +1,68 @@
+package ring
+
+import "fmt"
+
+// Boundary selects what the head reads when it leaves the sample.
+type Boundary string
+
+const (
+	// BoundaryWrap plays the sample as a loop.
+	BoundaryWrap Boundary = "wrap"
+	// BoundaryClamp holds the first or last frame.
+	BoundaryClamp Boundary = "clamp"
+	// BoundarySilence reads silence, like the run-in groove before a record.
+	BoundarySilence Boundary = "silence"
+	// BoundaryPingPong mirrors the sample back and forth.
+	BoundaryPingPong Boundary = "pingpong"
+
+	DefaultBoundary = BoundaryWrap
+)
+
+// ParseBoundary parses a boundary mode name as used on the command line and
+// in automation files.
+func ParseBoundary(s string) (Boundary, error) {
+	switch b := Boundary(s); b {
+	case BoundaryWrap, BoundaryClamp, BoundarySilence, BoundaryPingPong:
+		return b, nil
+	}
+	return "", fmt.Errorf("invalid boundary %q (expected %q, %q, %q or %q)",
+		s, BoundaryWrap, BoundaryClamp, BoundarySilence, BoundaryPingPong)
+}
+
+// boundaryIndex maps a frame index that may lie outside [0, n) to the frame
+// to read, or returns false when the boundary reads silence.
+func boundaryIndex(boundary Boundary, i int, n int) (int, bool) {
+	// An empty sample only has silence to read
+	if n == 0 {
+		return 0, false
+	}
+	if i >= 0 && i < n {
+		return i, true
+	}
+
+	switch boundary {
+	case BoundaryClamp:
+		return min(max(i, 0), n-1), true
+	case BoundarySilence:
+		return 0, false
+	case BoundaryPingPong:
+		if n == 1 {
+			return 0, true
+		}
+		period := 2 * (n - 1)
+		i %= period
+		if i < 0 {
+			i += period
+		}
+		if i >= n {
+			i = period - i
+		}
+		return i, true
+	default:
+		i %= n
+		if i < 0 {
+			i += n
+		}
+		return i, true
+	}
+}
@@ -0,0 +1,85 @@
+package ring
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"slices"
+)
+
+// Format is an audio file format the ring can play.
+type Format string
+
+const (
+	FormatWav  Format = "wav"
+	FormatAiff Format = "aiff"
+	FormatFlac Format = "flac"
+)
+
+// decoder opens the files of one format. sniff tells whether the first bytes
+// of a file belong to the format.
+type decoder struct {
+	format Format
+	sniff  func(header []byte) bool
+	open   func(r io.ReaderAt) (Store, []Marker, error)
+}
+
+var decoders = []decoder{
+	{format: FormatWav, sniff: isContainer("RIFF", "WAVE"), open: openWav},
+	{format: FormatAiff, sniff: isContainer("FORM", "AIFF", "AIFC"), open: openAiff},
+	{
+		format: FormatFlac,
+		sniff: func(header []byte) bool {
+			// Some taggers put an ID3 tag before the stream
+			return bytes.HasPrefix(header, []byte("fLaC")) || bytes.HasPrefix(header, []byte("ID3"))
+		},
+		open: openFlac,
+	},
+}
+
+// isContainer sniffs a RIFF or IFF file holding one of the given types.
+func isContainer(id string, types ...string) func([]byte) bool {
+	return func(header []byte) bool {
+		if len(header) < 12 || string(header[0:4]) != id {
+			return false
+		}
+		return slices.Contains(types, string(header[8:12]))
+	}
+}
+
+// SniffFormat tells the format of an audio file from its first bytes.
+func SniffFormat(r io.ReaderAt) (Format, error) {
+	d, err := sniff(r)
+	if err != nil {
+		return "", err
+	}
+	return d.format, nil
+}
+
+func sniff(r io.ReaderAt) (decoder, error) {
+	header := make([]byte, 12)
+	n, err := r.ReadAt(header, 0)
+	if err != nil && err != io.EOF {
+		return decoder{}, err
+	}
+	for _, d := range decoders {
+		if d.sniff(header[:n]) {
+			return d, nil
+		}
+	}
+	return decoder{}, fmt.Errorf("%w: expected a WAV, AIFF or FLAC file", ErrUnsupportedFormat)
+}
+
+// OpenStore returns a store holding the frames of an audio file in any
+// supported format, along with the markers of the file.
+func OpenStore(r io.ReaderAt) (Store, []Marker, error) {
+	d, err := sniff(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	store, markers, err := d.open(r)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", d.format, err)
+	}
+	return store, markers, nil
+}
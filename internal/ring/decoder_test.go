@@ -0,0 +1,408 @@
+package ring
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io/fs"
+	"math"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// bitWriter writes a big endian bit stream, to build FLAC streams.
+type bitWriter struct {
+	buf   []byte
+	cache uint64
+	n     uint
+}
+
+func (w *bitWriter) write(value uint64, n uint) {
+	for i := int(n) - 1; i >= 0; i-- {
+		w.cache = w.cache<<1 | value>>uint(i)&1
+		w.n++
+		if w.n == 8 {
+			w.buf = append(w.buf, byte(w.cache))
+			w.cache, w.n = 0, 0
+		}
+	}
+}
+
+func (w *bitWriter) writeSigned(value int64, n uint) {
+	w.write(uint64(value)&(1<<n-1), n)
+}
+
+func (w *bitWriter) align() {
+	for w.n != 0 {
+		w.write(0, 1)
+	}
+}
+
+// flacSubframe describes how a test encoder codes a channel of a frame.
+type flacSubframe struct {
+	kind         string
+	order        int
+	coefficients []int64
+	shift        uint
+	wasted       uint
+	// Rice coding of the residual; a parameter of -1 stores a partition
+	// verbatim
+	partitionOrder uint
+	parameters     []int
+	wideParameters bool
+}
+
+func (w *bitWriter) subframe(samples []int64, bits uint, sub flacSubframe) {
+	w.write(0, 1)
+	switch sub.kind {
+	case "constant":
+		w.write(0, 6)
+	case "verbatim":
+		w.write(1, 6)
+	case "fixed":
+		w.write(uint64(8+sub.order), 6)
+	case "lpc":
+		w.write(uint64(31+sub.order), 6)
+	}
+	if sub.wasted > 0 {
+		w.write(1, 1)
+		w.write(1, sub.wasted)
+		bits -= sub.wasted
+		shifted := make([]int64, len(samples))
+		for i, sample := range samples {
+			shifted[i] = sample >> sub.wasted
+		}
+		samples = shifted
+	} else {
+		w.write(0, 1)
+	}
+
+	switch sub.kind {
+	case "constant":
+		w.writeSigned(samples[0], bits)
+		return
+	case "verbatim":
+		for _, sample := range samples {
+			w.writeSigned(sample, bits)
+		}
+		return
+	}
+
+	coefficients, shift := sub.coefficients, sub.shift
+	if sub.kind == "fixed" {
+		coefficients, shift = fixedCoefficients[sub.order], 0
+	}
+	for _, sample := range samples[:sub.order] {
+		w.writeSigned(sample, bits)
+	}
+	if sub.kind == "lpc" {
+		w.write(15-1, 4)
+		w.writeSigned(int64(shift), 5)
+		for _, coefficient := range coefficients {
+			w.writeSigned(coefficient, 15)
+		}
+	}
+
+	residual := make([]int64, len(samples))
+	for i := sub.order; i < len(samples); i++ {
+		prediction := int64(0)
+		for j, coefficient := range coefficients {
+			prediction += coefficient * samples[i-1-j]
+		}
+		residual[i] = samples[i] - prediction>>shift
+	}
+
+	parameterBits := uint(4)
+	if sub.wideParameters {
+		w.write(1, 2)
+		parameterBits = 5
+	} else {
+		w.write(0, 2)
+	}
+	w.write(uint64(sub.partitionOrder), 4)
+	size := len(samples) >> sub.partitionOrder
+	for partition, parameter := range sub.parameters {
+		start := max(partition*size, sub.order)
+		end := (partition + 1) * size
+		if parameter < 0 {
+			w.write(1<<parameterBits-1, parameterBits)
+			w.write(24, 5)
+			for _, r := range residual[start:end] {
+				w.writeSigned(r, 24)
+			}
+			continue
+		}
+		w.write(uint64(parameter), parameterBits)
+		for _, r := range residual[start:end] {
+			folded := uint64(r<<1) ^ uint64(r>>63)
+			for range folded >> uint(parameter) {
+				w.write(0, 1)
+			}
+			w.write(1, 1)
+			w.write(folded&(1<<uint(parameter)-1), uint(parameter))
+		}
+	}
+}
+
+// flacFrame writes a 16-bit stereo frame of left and right samples.
+func (w *bitWriter) flacFrame(index int, left, right []int64, assignment int, blockSizeCode uint64, subs [2]flacSubframe) {
+	w.write(flacFrameSync, 14)
+	w.write(0, 2)
+	w.write(blockSizeCode, 4)
+	w.write(0, 4)
+	w.write(uint64(assignment), 4)
+	w.write(4, 3)
+	w.write(0, 1)
+	w.write(uint64(index), 8)
+	switch blockSizeCode {
+	case 6:
+		w.write(uint64(len(left)-1), 8)
+	case 7:
+		w.write(uint64(len(left)-1), 16)
+	}
+	w.write(0, 8)
+
+	channels := [2][]int64{left, right}
+	bits := [2]uint{16, 16}
+	side := make([]int64, len(left))
+	for i := range left {
+		side[i] = left[i] - right[i]
+	}
+	switch assignment {
+	case flacChannelsLeftSide:
+		channels[1], bits[1] = side, 17
+	case flacChannelsSideRight:
+		channels[0], bits[0] = side, 17
+	case flacChannelsMidSide:
+		mid := make([]int64, len(left))
+		for i := range left {
+			mid[i] = (left[i] + right[i]) >> 1
+		}
+		channels[0], channels[1], bits[1] = mid, side, 17
+	}
+	for ch := range channels {
+		w.subframe(channels[ch], bits[ch], subs[ch])
+	}
+
+	w.align()
+	w.write(0, 16)
+}
+
+func flacMetadataBlock(kind byte, last bool, body []byte) []byte {
+	if last {
+		kind |= 0x80
+	}
+	size := len(body)
+	return append([]byte{kind, byte(size >> 16), byte(size >> 8), byte(size)}, body...)
+}
+
+func TestFlac(t *testing.T) {
+	const blockSize = 64
+	left, right := []int64{}, []int64{}
+	for i := range 6 * blockSize {
+		left = append(left, int64(20000*math.Sin(float64(i)/7)))
+		right = append(right, int64(15000*math.Cos(float64(i)/5))&^3)
+	}
+	block := func(i int) ([]int64, []int64) {
+		return left[i*blockSize : (i+1)*blockSize], right[i*blockSize : (i+1)*blockSize]
+	}
+	// The first block has a constant left channel, the last one a silent
+	// side channel
+	for i := range blockSize {
+		left[i] = -1234
+	}
+	copy(right[5*blockSize:], left[5*blockSize:])
+
+	w := &bitWriter{}
+	l, r := block(0)
+	w.flacFrame(0, l, r, 1, 7, [2]flacSubframe{
+		{kind: "constant"},
+		{kind: "verbatim"},
+	})
+	l, r = block(1)
+	w.flacFrame(1, l, r, flacChannelsMidSide, 7, [2]flacSubframe{
+		{kind: "fixed", order: 2, partitionOrder: 1, parameters: []int{5, 7}},
+		{kind: "lpc", order: 3, coefficients: []int64{3000, -1000, 200}, shift: 11, partitionOrder: 2, parameters: []int{12, -1, 13, 11}},
+	})
+	l, r = block(2)
+	w.flacFrame(2, l, r, flacChannelsLeftSide, 6, [2]flacSubframe{
+		{kind: "fixed", order: 4, parameters: []int{9}, wideParameters: true},
+		{kind: "fixed", order: 0, parameters: []int{14}},
+	})
+	l, r = block(3)
+	w.flacFrame(3, l, r, flacChannelsSideRight, 7, [2]flacSubframe{
+		{kind: "fixed", order: 1, parameters: []int{10}},
+		{kind: "fixed", order: 3, parameters: []int{8}, wasted: 2},
+	})
+	l, r = block(4)
+	w.flacFrame(4, l, r, 1, 7, [2]flacSubframe{
+		{kind: "lpc", order: 1, coefficients: []int64{1 << 10}, shift: 10, parameters: []int{11}},
+		{kind: "verbatim", wasted: 2},
+	})
+	l, r = block(5)
+	w.flacFrame(5, l, r, flacChannelsMidSide, 7, [2]flacSubframe{
+		{kind: "verbatim"},
+		{kind: "constant"},
+	})
+	frames := w.buf
+
+	info := binary.BigEndian.AppendUint16(nil, blockSize)
+	info = binary.BigEndian.AppendUint16(info, blockSize)
+	info = append(info, 0, 0, 0, 0, 0, 0)
+	info = binary.BigEndian.AppendUint64(info, 44100<<44|1<<41|15<<36|uint64(len(left)))
+	info = append(info, make([]byte, 16)...)
+
+	cue := append(u32(1), u32(1, 0, 0x61746164, 0, 0, 128)...)
+	labl := append([]byte("adtl"), riffChunkBytes("labl", append(u32(1), "hit\x00"...))...)
+
+	file := []byte("ID3\x04\x00\x00\x00\x00\x00\x02\x00\x00fLaC")
+	file = append(file, flacMetadataBlock(flacStreamInfo, false, info)...)
+	file = append(file, flacMetadataBlock(flacApplication, false, []byte("riffRIFF\x00\x00\x00\x00WAVE"))...)
+	file = append(file, flacMetadataBlock(flacApplication, false, append([]byte("riff"), riffChunkBytes("data", nil)...))...)
+	file = append(file, flacMetadataBlock(flacApplication, false, append([]byte("riff"), riffChunkBytes("cue ", cue)...))...)
+	file = append(file, flacMetadataBlock(flacApplication, true, append([]byte("riff"), riffChunkBytes("LIST", labl)...))...)
+	file = append(file, frames...)
+
+	format, err := SniffFormat(bytes.NewReader(file))
+	require.NoError(t, err)
+	require.Equal(t, FormatFlac, format)
+
+	store, markers, err := OpenStore(bytes.NewReader(file))
+	require.NoError(t, err)
+	require.Equal(t, uint32(44100), store.SampleRate())
+	require.Equal(t, 2, store.NumChannels())
+	require.Equal(t, len(left), store.Frames())
+	for i := range left {
+		require.Equal(t, float64(left[i])/32768, store.Sample(i, 0), "left frame %d", i)
+		require.Equal(t, float64(right[i])/32768, store.Sample(i, 1), "right frame %d", i)
+	}
+	require.Equal(t, []Marker{{Name: "hit", Start: 128.0 / 44100, End: 128.0 / 44100}}, markers)
+
+	// A stream cut inside a frame is an error
+	_, _, err = OpenStore(bytes.NewReader(file[:len(file)-10]))
+	require.Error(t, err)
+
+	// So is a stream without frames
+	_, _, err = OpenStore(bytes.NewReader(file[:len(file)-len(frames)]))
+	require.ErrorIs(t, err, ErrNoFrames)
+}
+
+// The reference encoder codes 4700 frames of correlated stereo in blocks of
+// 4096 frames with LPC subframes and stereo decorrelation, the last block
+// partial.
+//go:generate flac --silent --force --best -o testdata/reference.flac testdata/reference.wav
+
+func TestFlacReference(t *testing.T) {
+	encoded, err := os.Open("testdata/reference.flac")
+	if errors.Is(err, fs.ErrNotExist) {
+		t.Skip("testdata/reference.flac is missing: encode it with go generate, which needs the flac tool")
+	}
+	require.NoError(t, err)
+	defer encoded.Close()
+	decoded, err := os.Open("testdata/reference.wav")
+	require.NoError(t, err)
+	defer decoded.Close()
+
+	flac, _, err := OpenStore(encoded)
+	require.NoError(t, err)
+	wav, _, err := OpenStore(decoded)
+	require.NoError(t, err)
+	require.Equal(t, wav.SampleRate(), flac.SampleRate())
+	require.Equal(t, wav.NumChannels(), flac.NumChannels())
+	require.Equal(t, wav.Frames(), flac.Frames())
+	require.NotZero(t, flac.Frames()%4096)
+	for i := range wav.Frames() {
+		for ch := range wav.NumChannels() {
+			require.Equal(t, wav.Sample(i, ch), flac.Sample(i, ch), "frame %d channel %d", i, ch)
+		}
+	}
+	require.NoError(t, flac.Err())
+}
+
+func TestAiff(t *testing.T) {
+	samples := []int16{-32768, -1, 0, 1, 12345, 32767}
+
+	chunk := func(id string, body []byte) []byte {
+		chunk := append([]byte(id), binary.BigEndian.AppendUint32(nil, uint32(len(body)))...)
+		chunk = append(chunk, body...)
+		if len(body)%2 == 1 {
+			chunk = append(chunk, 0)
+		}
+		return chunk
+	}
+	form := func(kind string, compression string, data []byte) []byte {
+		comm := binary.BigEndian.AppendUint16(nil, 2)
+		comm = binary.BigEndian.AppendUint32(comm, uint32(len(samples)/2))
+		comm = binary.BigEndian.AppendUint16(comm, 16)
+		// 22050 as an 80-bit extended float
+		comm = append(comm, 0x40, 0x0d, 0xac, 0x44, 0, 0, 0, 0, 0, 0)
+		if kind == "AIFC" {
+			comm = append(comm, compression...)
+			comm = append(comm, 0)
+		}
+		mark := binary.BigEndian.AppendUint16(nil, 2)
+		mark = append(mark, 0, 1, 0, 0, 0, 1, 3, 'h', 'i', 't')
+		mark = append(mark, 0, 2, 0, 0, 0, 2, 0, 0)
+
+		body := []byte(kind)
+		body = append(body, chunk("COMM", comm)...)
+		body = append(body, chunk("MARK", mark)...)
+		body = append(body, chunk("SSND", append(make([]byte, 8), data...))...)
+		return chunk("FORM", body)
+	}
+
+	bigEndian, littleEndian := []byte{}, []byte{}
+	for _, sample := range samples {
+		bigEndian = binary.BigEndian.AppendUint16(bigEndian, uint16(sample))
+		littleEndian = binary.LittleEndian.AppendUint16(littleEndian, uint16(sample))
+	}
+
+	for name, file := range map[string][]byte{
+		"aiff": form("AIFF", "", bigEndian),
+		"aifc": form("AIFC", "NONE", bigEndian),
+		"sowt": form("AIFC", "sowt", littleEndian),
+	} {
+		t.Run(name, func(t *testing.T) {
+			format, err := SniffFormat(bytes.NewReader(file))
+			require.NoError(t, err)
+			require.Equal(t, FormatAiff, format)
+
+			store, markers, err := OpenStore(bytes.NewReader(file))
+			require.NoError(t, err)
+			require.Equal(t, uint32(22050), store.SampleRate())
+			require.Equal(t, 2, store.NumChannels())
+			require.Equal(t, 3, store.Frames())
+			for i, sample := range samples {
+				require.Equal(t, float64(sample)/32768, store.Sample(i/2, i%2))
+			}
+			require.Equal(t, []Marker{
+				{Name: "hit", Start: 1.0 / 22050, End: 1.0 / 22050},
+				{Name: "2", Start: 2.0 / 22050, End: 2.0 / 22050},
+			}, markers)
+		})
+	}
+
+	_, _, err := OpenStore(bytes.NewReader(form("AIFF", "", nil)))
+	require.ErrorIs(t, err, ErrNoFrames)
+
+	// Samples wider than 32 bits are not supported whatever their byte order
+	for _, compression := range []string{"NONE", "sowt"} {
+		file := form("AIFC", compression, bigEndian)
+		// The sample size of the COMM chunk, after the FORM and COMM headers
+		binary.BigEndian.PutUint16(file[26:], 40)
+		_, _, err := OpenStore(bytes.NewReader(file))
+		require.ErrorIs(t, err, ErrUnsupportedFormat, compression)
+	}
+}
+
+func TestSniffFormat(t *testing.T) {
+	file := wavFile(wavFormatChunk(wavFormatPCM, 1, 8000, 16), riffChunkBytes("data", nil))
+	format, err := SniffFormat(bytes.NewReader(file))
+	require.NoError(t, err)
+	require.Equal(t, FormatWav, format)
+
+	_, err = SniffFormat(bytes.NewReader([]byte("OggS")))
+	require.ErrorIs(t, err, ErrUnsupportedFormat)
+}
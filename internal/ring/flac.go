@@ -0,0 +1,606 @@
+package ring
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	flacStreamInfo  = 0
+	flacApplication = 2
+
+	flacStreamInfoSize = 34
+	flacFrameSync      = 0x3ffe
+
+	flacChannelsLeftSide  = 8
+	flacChannelsSideRight = 9
+	flacChannelsMidSide   = 10
+)
+
+var errInvalidFlacFrame = errors.New("invalid FLAC frame")
+
+// flacStreamInfoBlock holds the fields of the STREAMINFO metadata block the
+// decoder needs.
+type flacStreamInfoBlock struct {
+	sampleRate    uint32
+	numChannels   int
+	bitsPerSample int
+	totalFrames   uint64
+}
+
+// flacCapacityHint caps the frames preallocated from the total in the
+// STREAMINFO block, which a corrupt file could set to anything.
+const flacCapacityHint = 1 << 20
+
+// openFlac decodes a FLAC file. FLAC frames have no fixed size, so unlike
+// WAV and AIFF files the whole file is decoded into memory up front, at
+// 4 bytes per sample. Markers are read from the WAV chunks that
+// "flac --keep-foreign-metadata" stores.
+func openFlac(r io.ReaderAt) (Store, []Marker, error) {
+	br := bufio.NewReader(io.NewSectionReader(r, 0, 1<<62))
+	if err := skipID3(br); err != nil {
+		return nil, nil, err
+	}
+
+	magic := make([]byte, 4)
+	if _, err := io.ReadFull(br, magic); err != nil {
+		return nil, nil, err
+	}
+	if string(magic) != "fLaC" {
+		return nil, nil, fmt.Errorf("not a FLAC file")
+	}
+
+	info, foreign, err := readFlacMetadata(br)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	channels := make([][]float32, info.numChannels)
+	for ch := range channels {
+		channels[ch] = make([]float32, 0, min(info.totalFrames, flacCapacityHint))
+	}
+	scale := float32(int64(1) << (info.bitsPerSample - 1))
+
+	decoder := &flacDecoder{bits: &bitReader{r: br}, info: info}
+	for {
+		block, err := decoder.frame()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, nil, err
+		}
+		for ch, samples := range block {
+			for _, sample := range samples {
+				channels[ch] = append(channels[ch], float32(sample)/scale)
+			}
+		}
+	}
+
+	if len(channels[0]) == 0 {
+		return nil, nil, fmt.Errorf("audio frames: %w", ErrNoFrames)
+	}
+
+	markers := []Marker{}
+	if foreign != nil {
+		r := bytes.NewReader(foreign)
+		chunks, err := readChunks(r)
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to read markers: %w", err)
+		}
+		if markers, err = readMarkers(r, chunks, info.sampleRate); err != nil {
+			return nil, nil, fmt.Errorf("unable to read markers: %w", err)
+		}
+	}
+
+	return NewMemoryStore(info.sampleRate, channels), markers, nil
+}
+
+// skipID3 skips the ID3v2 tag some taggers put before the FLAC stream.
+func skipID3(br *bufio.Reader) error {
+	header, err := br.Peek(10)
+	if err != nil || string(header[0:3]) != "ID3" {
+		return nil
+	}
+	// The size is a big endian integer of 7-bit bytes
+	size := int(header[6])<<21 | int(header[7])<<14 | int(header[8])<<7 | int(header[9])
+	_, err = br.Discard(10 + size)
+	return err
+}
+
+// readFlacMetadata reads the metadata blocks of a FLAC stream. It returns
+// the stream info and, when the file keeps the chunks of the WAV file it was
+// encoded from, those chunks as a RIFF file without audio data.
+func readFlacMetadata(br *bufio.Reader) (flacStreamInfoBlock, []byte, error) {
+	var info flacStreamInfoBlock
+	var foreign []byte
+	infoFound := false
+
+	header := make([]byte, 4)
+	for last := false; !last; {
+		if _, err := io.ReadFull(br, header); err != nil {
+			return info, nil, fmt.Errorf("unable to read metadata: %w", err)
+		}
+		last = header[0]&0x80 != 0
+		kind := header[0] & 0x7f
+		size := int(header[1])<<16 | int(header[2])<<8 | int(header[3])
+		body := make([]byte, size)
+		if _, err := io.ReadFull(br, body); err != nil {
+			return info, nil, fmt.Errorf("unable to read metadata: %w", err)
+		}
+
+		switch kind {
+		case flacStreamInfo:
+			if size < flacStreamInfoSize {
+				return info, nil, fmt.Errorf("stream info: %w", errTruncatedChunk)
+			}
+			fields := binary.BigEndian.Uint64(body[10:18])
+			info.sampleRate = uint32(fields >> 44)
+			info.numChannels = int(fields>>41&0x7) + 1
+			info.bitsPerSample = int(fields>>36&0x1f) + 1
+			info.totalFrames = fields & 0xfffffffff
+			infoFound = true
+		case flacApplication:
+			if size < 4 || string(body[0:4]) != "riff" {
+				continue
+			}
+			foreign = appendForeignChunk(foreign, body[4:])
+		}
+	}
+
+	if !infoFound {
+		return info, nil, fmt.Errorf("stream info not found")
+	}
+	if info.sampleRate == 0 {
+		return info, nil, fmt.Errorf("invalid format: %d channels at %d Hz", info.numChannels, info.sampleRate)
+	}
+	return info, foreign, nil
+}
+
+// appendForeignChunk adds a WAV chunk stored in a "riff" application block
+// to the RIFF file rebuilt from them. The audio data is in the FLAC frames,
+// so the data chunk is left out.
+func appendForeignChunk(foreign []byte, chunk []byte) []byte {
+	if len(chunk) >= riffHeaderSize && string(chunk[0:4]) == "RIFF" {
+		foreign = append([]byte("RIFF\x00\x00\x00\x00"), chunk[8:riffHeaderSize]...)
+		chunk = chunk[riffHeaderSize:]
+	}
+	if foreign == nil || len(chunk) < chunkHeaderSize || string(chunk[0:4]) == "data" {
+		return foreign
+	}
+	return append(foreign, chunk...)
+}
+
+// bitReader reads a big endian bit stream.
+type bitReader struct {
+	r     io.ByteReader
+	cache uint64
+	n     uint
+}
+
+// read reads an unsigned integer of up to 56 bits.
+func (b *bitReader) read(n uint) (uint64, error) {
+	for b.n < n {
+		c, err := b.r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		b.cache = b.cache<<8 | uint64(c)
+		b.n += 8
+	}
+	b.n -= n
+	value := b.cache >> b.n & (1<<n - 1)
+	b.cache &= 1<<b.n - 1
+	return value, nil
+}
+
+// readSigned reads a two's complement integer of up to 56 bits.
+func (b *bitReader) readSigned(n uint) (int64, error) {
+	value, err := b.read(n)
+	if err != nil || n == 0 {
+		return 0, err
+	}
+	return int64(value<<(64-n)) >> (64 - n), nil
+}
+
+// readUnary counts the zero bits before the next one bit.
+func (b *bitReader) readUnary() (uint64, error) {
+	count := uint64(0)
+	for {
+		bit, err := b.read(1)
+		if err != nil {
+			return 0, err
+		}
+		if bit == 1 {
+			return count, nil
+		}
+		count++
+	}
+}
+
+// align drops the bits left in the current byte.
+func (b *bitReader) align() {
+	b.n -= b.n % 8
+	b.cache &= 1<<b.n - 1
+}
+
+// flacDecoder decodes the frames of a FLAC stream.
+type flacDecoder struct {
+	bits *bitReader
+	info flacStreamInfoBlock
+}
+
+// frame decodes the next frame into one slice of samples per channel. It
+// returns io.EOF at the end of the stream.
+func (d *flacDecoder) frame() ([][]int64, error) {
+	sync, err := d.bits.read(14)
+	if err != nil {
+		return nil, err
+	}
+	if sync != flacFrameSync {
+		return nil, errInvalidFlacFrame
+	}
+
+	header, err := d.bits.read(18)
+	if err != nil {
+		return nil, noEOF(err)
+	}
+	blockSizeCode := header >> 12 & 0xf
+	sampleRateCode := header >> 8 & 0xf
+	assignment := int(header >> 4 & 0xf)
+	sampleSizeCode := header >> 1 & 0x7
+
+	// The coded frame or sample number is skipped, frames are read in order
+	if err := d.skipCodedNumber(); err != nil {
+		return nil, noEOF(err)
+	}
+
+	blockSize, err := d.blockSize(blockSizeCode)
+	if err != nil {
+		return nil, noEOF(err)
+	}
+	switch sampleRateCode {
+	case 12:
+		_, err = d.bits.read(8)
+	case 13, 14:
+		_, err = d.bits.read(16)
+	case 15:
+		return nil, errInvalidFlacFrame
+	}
+	if err != nil {
+		return nil, noEOF(err)
+	}
+	bitsPerSample, err := d.bitsPerSample(sampleSizeCode)
+	if err != nil {
+		return nil, err
+	}
+	// CRC-8 of the header
+	if _, err := d.bits.read(8); err != nil {
+		return nil, noEOF(err)
+	}
+
+	numChannels := assignment + 1
+	if assignment >= flacChannelsLeftSide {
+		if assignment > flacChannelsMidSide {
+			return nil, errInvalidFlacFrame
+		}
+		numChannels = 2
+	}
+	if numChannels != d.info.numChannels {
+		return nil, fmt.Errorf("%w: %d channels in a %d channel stream", errInvalidFlacFrame, numChannels, d.info.numChannels)
+	}
+
+	channels := make([][]int64, numChannels)
+	for ch := range channels {
+		// The side channel has one more bit
+		bits := bitsPerSample
+		switch {
+		case assignment == flacChannelsLeftSide && ch == 1,
+			assignment == flacChannelsSideRight && ch == 0,
+			assignment == flacChannelsMidSide && ch == 1:
+			bits++
+		}
+		if channels[ch], err = d.subframe(blockSize, bits); err != nil {
+			return nil, noEOF(err)
+		}
+	}
+
+	// Padding to the byte boundary and CRC-16 of the frame
+	d.bits.align()
+	if _, err := d.bits.read(16); err != nil {
+		return nil, noEOF(err)
+	}
+
+	decorrelate(assignment, channels)
+	return channels, nil
+}
+
+func (d *flacDecoder) skipCodedNumber() error {
+	first, err := d.bits.read(8)
+	if err != nil {
+		return err
+	}
+	// The number of leading ones gives the number of bytes, as in UTF-8
+	extra := 0
+	for mask := uint64(0x80); first&mask != 0 && mask > 1; mask >>= 1 {
+		extra++
+	}
+	if extra == 1 || extra > 7 {
+		return errInvalidFlacFrame
+	}
+	for range max(extra-1, 0) {
+		if _, err := d.bits.read(8); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *flacDecoder) blockSize(code uint64) (int, error) {
+	switch {
+	case code == 0:
+		return 0, errInvalidFlacFrame
+	case code == 1:
+		return 192, nil
+	case code <= 5:
+		return 576 << (code - 2), nil
+	case code == 6:
+		n, err := d.bits.read(8)
+		return int(n) + 1, err
+	case code == 7:
+		n, err := d.bits.read(16)
+		return int(n) + 1, err
+	default:
+		return 256 << (code - 8), nil
+	}
+}
+
+func (d *flacDecoder) bitsPerSample(code uint64) (int, error) {
+	switch code {
+	case 0:
+		return d.info.bitsPerSample, nil
+	case 1:
+		return 8, nil
+	case 2:
+		return 12, nil
+	case 4:
+		return 16, nil
+	case 5:
+		return 20, nil
+	case 6:
+		return 24, nil
+	case 7:
+		return 32, nil
+	}
+	return 0, errInvalidFlacFrame
+}
+
+// subframe decodes the samples of one channel.
+func (d *flacDecoder) subframe(blockSize int, bits int) ([]int64, error) {
+	header, err := d.bits.read(8)
+	if err != nil {
+		return nil, err
+	}
+	if header&0x80 != 0 {
+		return nil, errInvalidFlacFrame
+	}
+	kind := header >> 1 & 0x3f
+
+	// Wasted bits are zero low bits shared by all the samples
+	wasted := 0
+	if header&1 != 0 {
+		n, err := d.bits.readUnary()
+		if err != nil {
+			return nil, err
+		}
+		wasted = int(n) + 1
+		bits -= wasted
+	}
+
+	samples := make([]int64, blockSize)
+	switch {
+	case kind == 0:
+		value, err := d.bits.readSigned(uint(bits))
+		if err != nil {
+			return nil, err
+		}
+		for i := range samples {
+			samples[i] = value
+		}
+	case kind == 1:
+		for i := range samples {
+			if samples[i], err = d.bits.readSigned(uint(bits)); err != nil {
+				return nil, err
+			}
+		}
+	case kind >= 8 && kind <= 12:
+		if err := d.fixed(samples, int(kind-8), bits); err != nil {
+			return nil, err
+		}
+	case kind >= 32:
+		if err := d.lpc(samples, int(kind-31), bits); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errInvalidFlacFrame
+	}
+
+	if wasted > 0 {
+		for i := range samples {
+			samples[i] <<= wasted
+		}
+	}
+	return samples, nil
+}
+
+// fixedCoefficients are the coefficients of the fixed predictors, by order.
+var fixedCoefficients = [][]int64{
+	{},
+	{1},
+	{2, -1},
+	{3, -3, 1},
+	{4, -6, 4, -1},
+}
+
+func (d *flacDecoder) fixed(samples []int64, order int, bits int) error {
+	if err := d.warmUp(samples, order, bits); err != nil {
+		return err
+	}
+	if err := d.residual(samples, order); err != nil {
+		return err
+	}
+	predict(samples, fixedCoefficients[order], 0)
+	return nil
+}
+
+func (d *flacDecoder) lpc(samples []int64, order int, bits int) error {
+	if err := d.warmUp(samples, order, bits); err != nil {
+		return err
+	}
+	precision, err := d.bits.read(4)
+	if err != nil {
+		return err
+	}
+	if precision == 15 {
+		return errInvalidFlacFrame
+	}
+	shift, err := d.bits.readSigned(5)
+	if err != nil {
+		return err
+	}
+	if shift < 0 {
+		return errInvalidFlacFrame
+	}
+	coefficients := make([]int64, order)
+	for i := range coefficients {
+		if coefficients[i], err = d.bits.readSigned(uint(precision + 1)); err != nil {
+			return err
+		}
+	}
+	if err := d.residual(samples, order); err != nil {
+		return err
+	}
+	predict(samples, coefficients, uint(shift))
+	return nil
+}
+
+func (d *flacDecoder) warmUp(samples []int64, order int, bits int) error {
+	if order > len(samples) {
+		return errInvalidFlacFrame
+	}
+	var err error
+	for i := range order {
+		if samples[i], err = d.bits.readSigned(uint(bits)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// residual reads the Rice coded prediction errors into the samples after
+// the warm-up ones.
+func (d *flacDecoder) residual(samples []int64, order int) error {
+	method, err := d.bits.read(2)
+	if err != nil {
+		return err
+	}
+	parameterBits := uint(4)
+	switch method {
+	case 0:
+	case 1:
+		parameterBits = 5
+	default:
+		return errInvalidFlacFrame
+	}
+	escape := uint64(1)<<parameterBits - 1
+
+	partitionOrder, err := d.bits.read(4)
+	if err != nil {
+		return err
+	}
+	partitionSize := len(samples) >> partitionOrder
+	if partitionSize<<partitionOrder != len(samples) || partitionSize < order {
+		return errInvalidFlacFrame
+	}
+
+	i := order
+	for partition := range 1 << partitionOrder {
+		end := (partition + 1) * partitionSize
+		parameter, err := d.bits.read(parameterBits)
+		if err != nil {
+			return err
+		}
+
+		if parameter == escape {
+			// The partition is stored verbatim
+			bits, err := d.bits.read(5)
+			if err != nil {
+				return err
+			}
+			for ; i < end; i++ {
+				if samples[i], err = d.bits.readSigned(uint(bits)); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		for ; i < end; i++ {
+			quotient, err := d.bits.readUnary()
+			if err != nil {
+				return err
+			}
+			remainder, err := d.bits.read(uint(parameter))
+			if err != nil {
+				return err
+			}
+			folded := quotient<<parameter | remainder
+			samples[i] = int64(folded>>1) ^ -int64(folded&1)
+		}
+	}
+
+	return nil
+}
+
+// predict adds the linear prediction of each sample after the warm-up ones
+// to its residual.
+func predict(samples []int64, coefficients []int64, shift uint) {
+	for i := len(coefficients); i < len(samples); i++ {
+		prediction := int64(0)
+		for j, coefficient := range coefficients {
+			prediction += coefficient * samples[i-1-j]
+		}
+		samples[i] += prediction >> shift
+	}
+}
+
+// decorrelate turns the stereo channels of a frame back into left and right.
+func decorrelate(assignment int, channels [][]int64) {
+	switch assignment {
+	case flacChannelsLeftSide:
+		for i, side := range channels[1] {
+			channels[1][i] = channels[0][i] - side
+		}
+	case flacChannelsSideRight:
+		for i, side := range channels[0] {
+			channels[0][i] = side + channels[1][i]
+		}
+	case flacChannelsMidSide:
+		for i, side := range channels[1] {
+			mid := channels[0][i]<<1 | side&1
+			channels[0][i] = (mid + side) >> 1
+			channels[1][i] = (mid - side) >> 1
+		}
+	}
+}
+
+// noEOF reports a stream that ends inside a frame as truncated.
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
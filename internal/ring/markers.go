@@ -0,0 +1,248 @@
+package ring
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// Marker is a named position of the sample, read from the cue points, labels
+// and loops a wave editor stores in the file. Start and End are in seconds;
+// End equals Start for a plain cue point.
+type Marker struct {
+	Name  string
+	Start float64
+	End   float64
+}
+
+const (
+	riffHeaderSize  = 12
+	chunkHeaderSize = 8
+	cuePointSize    = 24
+	smplHeaderSize  = 36
+	sampleLoopSize  = 24
+)
+
+var errTruncatedChunk = errors.New("truncated chunk")
+
+// riffChunk is a chunk of a RIFF file, whose body starts at offset.
+type riffChunk struct {
+	id     string
+	offset int64
+	size   uint32
+}
+
+// readChunks lists the top-level chunks of a RIFF file.
+func readChunks(r io.ReaderAt) ([]riffChunk, error) {
+	header := make([]byte, riffHeaderSize)
+	if _, err := r.ReadAt(header, 0); err != nil {
+		return nil, err
+	}
+	if string(header[0:4]) != "RIFF" {
+		return nil, fmt.Errorf("not a RIFF file")
+	}
+
+	chunks := []riffChunk{}
+	offset := int64(riffHeaderSize)
+	for {
+		if _, err := r.ReadAt(header[:chunkHeaderSize], offset); err != nil {
+			// A file cut inside a chunk header simply has no more chunks
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return chunks, nil
+			}
+			return nil, err
+		}
+		chunk := riffChunk{
+			id:     string(header[0:4]),
+			offset: offset + chunkHeaderSize,
+			size:   binary.LittleEndian.Uint32(header[4:8]),
+		}
+		chunks = append(chunks, chunk)
+		// Chunks are padded to an even size
+		offset = chunk.offset + int64(chunk.size) + int64(chunk.size%2)
+	}
+}
+
+func (c riffChunk) read(r io.ReaderAt) ([]byte, error) {
+	body := make([]byte, c.size)
+	if _, err := r.ReadAt(body, c.offset); err != nil {
+		return nil, fmt.Errorf("%q chunk: %w", c.id, errTruncatedChunk)
+	}
+	return body, nil
+}
+
+// cuePoint is an entry of a "cue " chunk, with its label and the length of
+// the region it starts from the "adtl" list.
+type cuePoint struct {
+	id     uint32
+	frame  uint32
+	length uint32
+	label  string
+}
+
+// readMarkers reads the markers of a WAV file: every cue point, named after
+// its label or its ID when it has none, and every loop of the "smpl" chunk,
+// named after the label of its cue point or "loop1", "loop2" and so on.
+func readMarkers(r io.ReaderAt, chunks []riffChunk, sampleRate uint32) ([]Marker, error) {
+	cues := []*cuePoint{}
+	cuesByID := map[uint32]*cuePoint{}
+	var lists, samplers [][]byte
+	for _, chunk := range chunks {
+		switch chunk.id {
+		case "cue ", "LIST", "smpl":
+		default:
+			continue
+		}
+		body, err := chunk.read(r)
+		if err != nil {
+			return nil, err
+		}
+
+		switch chunk.id {
+		case "cue ":
+			if len(body) < 4 {
+				return nil, fmt.Errorf("%q chunk: %w", chunk.id, errTruncatedChunk)
+			}
+			count := int(binary.LittleEndian.Uint32(body))
+			if len(body) < 4+count*cuePointSize {
+				return nil, fmt.Errorf("%q chunk: %w", chunk.id, errTruncatedChunk)
+			}
+			for i := range count {
+				entry := body[4+i*cuePointSize:]
+				cue := &cuePoint{
+					id: binary.LittleEndian.Uint32(entry[0:4]),
+					// The sample offset is the frame in the data chunk
+					frame: binary.LittleEndian.Uint32(entry[20:24]),
+				}
+				cues = append(cues, cue)
+				cuesByID[cue.id] = cue
+			}
+		case "LIST":
+			lists = append(lists, body)
+		case "smpl":
+			samplers = append(samplers, body)
+		}
+	}
+
+	// Labels may come before or after the cue points they name
+	for _, list := range lists {
+		if err := readLabels(list, cuesByID); err != nil {
+			return nil, err
+		}
+	}
+
+	markers := []Marker{}
+	seconds := func(frame uint32) float64 { return float64(frame) / float64(sampleRate) }
+	for _, cue := range cues {
+		name := cue.label
+		if name == "" {
+			name = strconv.FormatUint(uint64(cue.id), 10)
+		}
+		markers = append(markers, Marker{
+			Name:  name,
+			Start: seconds(cue.frame),
+			End:   seconds(cue.frame + cue.length),
+		})
+	}
+
+	loops := 0
+	for _, sampler := range samplers {
+		if len(sampler) < smplHeaderSize {
+			return nil, fmt.Errorf("%q chunk: %w", "smpl", errTruncatedChunk)
+		}
+		count := int(binary.LittleEndian.Uint32(sampler[28:32]))
+		if len(sampler) < smplHeaderSize+count*sampleLoopSize {
+			return nil, fmt.Errorf("%q chunk: %w", "smpl", errTruncatedChunk)
+		}
+		for i := range count {
+			entry := sampler[smplHeaderSize+i*sampleLoopSize:]
+			loops++
+			name := "loop" + strconv.Itoa(loops)
+			if cue, ok := cuesByID[binary.LittleEndian.Uint32(entry[0:4])]; ok && cue.label != "" {
+				name = cue.label
+			}
+			// The loop end is the last frame played
+			loop := Marker{
+				Name:  name,
+				Start: seconds(binary.LittleEndian.Uint32(entry[8:12])),
+				End:   seconds(binary.LittleEndian.Uint32(entry[12:16]) + 1),
+			}
+
+			// A loop replaces the cue point it is attached to
+			replaced := false
+			for j := range markers {
+				if markers[j].Name == loop.Name {
+					markers[j] = loop
+					replaced = true
+					break
+				}
+			}
+			if !replaced {
+				markers = append(markers, loop)
+			}
+		}
+	}
+
+	return markers, nil
+}
+
+// readLabels reads the "labl" and "ltxt" entries of an "adtl" list into the
+// cue points they belong to.
+func readLabels(list []byte, cues map[uint32]*cuePoint) error {
+	if len(list) < 4 || string(list[0:4]) != "adtl" {
+		return nil
+	}
+
+	for pos := 4; pos+chunkHeaderSize <= len(list); {
+		id := string(list[pos : pos+4])
+		size := int(binary.LittleEndian.Uint32(list[pos+4 : pos+8]))
+		body := list[pos+chunkHeaderSize:]
+		if len(body) < size {
+			return fmt.Errorf("%q chunk: %w", id, errTruncatedChunk)
+		}
+		body = body[:size]
+		pos += chunkHeaderSize + size + size%2
+
+		if len(body) < 4 {
+			continue
+		}
+		cue, ok := cues[binary.LittleEndian.Uint32(body[0:4])]
+		if !ok {
+			continue
+		}
+		switch id {
+		case "labl":
+			cue.label = cString(body[4:])
+		case "ltxt":
+			if len(body) >= 8 {
+				cue.length = binary.LittleEndian.Uint32(body[4:8])
+			}
+		}
+	}
+
+	return nil
+}
+
+// cString returns the text of a null-terminated string.
+func cString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
+
+// Markers returns the markers of the sample in the order they are stored.
+func (r *Ring) Markers() []Marker { return r.markers }
+
+// Marker returns the first marker with the given name.
+func (r *Ring) Marker(name string) (Marker, bool) {
+	for _, marker := range r.markers {
+		if marker.Name == name {
+			return marker, true
+		}
+	}
+	return Marker{}, false
+}
@@ -0,0 +1,180 @@
+package ring
+
+import (
+	"container/list"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/zaf/g711"
+)
+
+const (
+	// pcmChunkFrames is the number of frames decoded at once.
+	pcmChunkFrames = 8192
+	// pcmCachedChunks is the number of decoded chunks kept in memory, which
+	// bounds the memory used by a store whatever the length of the file.
+	pcmCachedChunks = 64
+)
+
+// pcmChunk is a run of decoded frames, interleaved.
+type pcmChunk struct {
+	index   int
+	samples []float32
+}
+
+// PCMStore is a Store decoding interleaved frames of fixed width, as found
+// in WAV and AIFF files, on demand and a chunk at a time. Only the most
+// recently used chunks are kept, so a store needs the same memory for a
+// short hit and for a full track, and playback starts without decoding the
+// whole file.
+type PCMStore struct {
+	r           io.ReaderAt
+	sampleRate  uint32
+	numChannels int
+	frames      int
+	dataOffset  int64
+	// width is the size of a sample in bytes.
+	width  int
+	decode func([]byte) float32
+
+	chunks map[int]*list.Element
+	lru    *list.List
+	last   *pcmChunk
+	err    error
+}
+
+var _ Store = (*PCMStore)(nil)
+
+func newPCMStore(r io.ReaderAt) *PCMStore {
+	return &PCMStore{
+		r:      r,
+		chunks: map[int]*list.Element{},
+		lru:    list.New(),
+	}
+}
+
+func (s *PCMStore) SampleRate() uint32 { return s.sampleRate }
+func (s *PCMStore) NumChannels() int   { return s.numChannels }
+func (s *PCMStore) Frames() int        { return s.frames }
+func (s *PCMStore) Err() error         { return s.err }
+
+func (s *PCMStore) blockAlign() int {
+	return s.width * s.numChannels
+}
+
+func (s *PCMStore) Sample(i int, ch int) float64 {
+	index := i / pcmChunkFrames
+	chunk := s.last
+	if chunk == nil || chunk.index != index {
+		chunk = s.chunk(index)
+		s.last = chunk
+	}
+
+	pos := (i%pcmChunkFrames)*s.numChannels + ch
+	if pos >= len(chunk.samples) {
+		return 0
+	}
+	return float64(chunk.samples[pos])
+}
+
+// chunk returns a decoded chunk, decoding it and evicting the least recently
+// used one if needed.
+func (s *PCMStore) chunk(index int) *pcmChunk {
+	if element, ok := s.chunks[index]; ok {
+		s.lru.MoveToFront(element)
+		return element.Value.(*pcmChunk)
+	}
+
+	if s.lru.Len() >= pcmCachedChunks {
+		oldest := s.lru.Back()
+		s.lru.Remove(oldest)
+		delete(s.chunks, oldest.Value.(*pcmChunk).index)
+	}
+
+	blockAlign := s.blockAlign()
+	chunk := &pcmChunk{index: index}
+	frames := min(pcmChunkFrames, s.frames-index*pcmChunkFrames)
+	raw := make([]byte, frames*blockAlign)
+	n, err := s.r.ReadAt(raw, s.dataOffset+int64(index)*pcmChunkFrames*int64(blockAlign))
+	if err != nil && !(err == io.EOF && n == len(raw)) && s.err == nil {
+		s.err = fmt.Errorf("unable to read frames: %w", err)
+	}
+
+	// Frames that could not be read stay silent
+	chunk.samples = make([]float32, frames*s.numChannels)
+	for frame := range n / blockAlign {
+		for ch := range s.numChannels {
+			offset := frame*blockAlign + ch*s.width
+			chunk.samples[frame*s.numChannels+ch] = s.decode(raw[offset : offset+s.width])
+		}
+	}
+
+	s.chunks[index] = s.lru.PushFront(chunk)
+	return chunk
+}
+
+// dataSize returns the number of bytes of a data chunk. Files written by
+// streaming encoders may not know it, or may be cut short, so the declared
+// size is only trusted when the reader holds that many bytes.
+func dataSize(r io.ReaderAt, offset int64, size int64) int64 {
+	if size > 0 && readableAt(r, offset+size-1) {
+		return size
+	}
+
+	// Find the end of the reader by bisection
+	low, high := int64(0), int64(math.MaxUint32)
+	for low < high {
+		mid := low + (high-low+1)/2
+		if readableAt(r, offset+mid-1) {
+			low = mid
+		} else {
+			high = mid - 1
+		}
+	}
+	return low
+}
+
+func readableAt(r io.ReaderAt, offset int64) bool {
+	_, err := r.ReadAt(make([]byte, 1), offset)
+	return err == nil
+}
+
+// decodeInt returns a decoder for signed integer samples of width bytes.
+// Samples narrower than their container are left justified, so decoding at
+// the container width gives the right scale.
+func decodeInt(width int, order binary.ByteOrder) func([]byte) float32 {
+	shift := 32 - 8*width
+	return func(b []byte) float32 {
+		var v uint32
+		for i := range b {
+			if order == binary.BigEndian {
+				v |= uint32(b[i]) << (8 * (width - 1 - i))
+			} else {
+				v |= uint32(b[i]) << (8 * i)
+			}
+		}
+		return float32(int32(v<<shift)) / (1 << 31)
+	}
+}
+
+func decodeUnsigned8(b []byte) float32 {
+	return (float32(b[0]) - 128) / 128
+}
+
+func decodeFloat32(order binary.ByteOrder) func([]byte) float32 {
+	return func(b []byte) float32 { return math.Float32frombits(order.Uint32(b)) }
+}
+
+func decodeFloat64(order binary.ByteOrder) func([]byte) float32 {
+	return func(b []byte) float32 { return float32(math.Float64frombits(order.Uint64(b))) }
+}
+
+func decodeALaw(b []byte) float32 {
+	return float32(g711.DecodeAlawFrame(b[0])) / (1 << 15)
+}
+
+func decodeMULaw(b []byte) float32 {
+	return float32(g711.DecodeUlawFrame(b[0])) / (1 << 15)
+}
@@ -0,0 +1,26 @@
+package ring
+
+import "fmt"
+
+// Quality selects how the ring reads samples between source frames.
+type Quality string
+
+const (
+	// QualityLinear interpolates linearly between neighbouring frames. It is
+	// cheap but aliases when the head moves fast.
+	QualityLinear Quality = "linear"
+	// QualitySinc uses a windowed-sinc filter whose cutoff follows the head
+	// speed, so fast moves stay band-limited.
+	QualitySinc Quality = "sinc"
+
+	DefaultQuality = QualityLinear
+)
+
+// ParseQuality parses a quality name as used on the command line.
+func ParseQuality(s string) (Quality, error) {
+	switch q := Quality(s); q {
+	case QualityLinear, QualitySinc:
+		return q, nil
+	}
+	return "", fmt.Errorf("invalid quality %q (expected %q or %q)", s, QualityLinear, QualitySinc)
+}
@@ -0,0 +1,44 @@
+package ring
+
+import (
+	"errors"
+	"math"
+)
+
+var ErrInvalidRegion = errors.New("invalid region")
+
+// SetRegion restricts the ring to the part of the sample between start and
+// end, in seconds. The head keeps addressing the whole sample, but the
+// boundary mode applies at the region bounds instead of the sample bounds.
+// The region is clipped to the sample.
+func (r *Ring) SetRegion(start, end float64) error {
+	first, last, err := r.regionFrames(start, end)
+	if err != nil {
+		return err
+	}
+	r.regionStart = first
+	r.regionEnd = last
+	return nil
+}
+
+// CheckRegion returns the error SetRegion would return for a region, without
+// setting it.
+func (r *Ring) CheckRegion(start, end float64) error {
+	_, _, err := r.regionFrames(start, end)
+	return err
+}
+
+func (r *Ring) regionFrames(start, end float64) (int, int, error) {
+	first := max(int(math.Round(start*float64(r.sampleRate))), 0)
+	last := min(int(math.Round(end*float64(r.sampleRate))), r.frames)
+	if first >= last {
+		return 0, 0, ErrInvalidRegion
+	}
+	return first, last, nil
+}
+
+// ResetRegion makes the whole sample playable again.
+func (r *Ring) ResetRegion() {
+	r.regionStart = 0
+	r.regionEnd = r.frames
+}
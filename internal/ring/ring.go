@@ -3,95 +3,119 @@ package ring
 import (
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
 	"math"
 	"time"
-
-	"github.com/youpy/go-wav"
 )
 
 const SizeofFloat32 = 4
 
-var ErrInvalidBufferSize = errors.New("invalid buffer size")
+var (
+	ErrInvalidBufferSize = errors.New("invalid buffer size")
+	ErrInvalidSeek       = errors.New("invalid seek")
+)
 
 type Ring struct {
-	buffers      [][]float64
-	samplesCount uint32
-	sampleRate   uint32
-	numChannels  uint16
+	store       Store
+	frames      int
+	sampleRate  uint32
+	outputRate  uint32
+	numChannels uint16
 
 	realTime       float64
+	startTime      float64
 	headPositionFn func(float64) float64
+	gainFn         func(float64) float64
 	maxDuration    float64
+	quality        Quality
+	boundary       Boundary
+	regionStart    int
+	regionEnd      int
+	markers        []Marker
+	// speed is how fast time runs, 1 being normal speed.
+	speed float64
 }
 
-func NewRingFromWav(file Reader) (*Ring, error) {
-	ring := &Ring{}
-
-	if err := ring.initialize(file); err != nil {
-		return nil, err
+// NewRing returns a ring playing the frames of a store.
+func NewRing(store Store, markers []Marker) *Ring {
+	r := &Ring{
+		store:       store,
+		frames:      store.Frames(),
+		sampleRate:  store.SampleRate(),
+		outputRate:  store.SampleRate(),
+		numChannels: uint16(store.NumChannels()),
+		markers:     markers,
+		speed:       1,
 	}
 
-	return ring, nil
+	r.headPositionFn = func(t float64) float64 { return t }
+	r.gainFn = func(float64) float64 { return 1.0 }
+	r.quality = DefaultQuality
+	r.boundary = DefaultBoundary
+	r.ResetRegion()
+
+	return r
 }
 
-func (r *Ring) initialize(file Reader) error {
-	reader := wav.NewReader(file)
-	format, err := reader.Format()
+// NewRingFromWav returns a ring playing a WAV file. Frames are decoded from
+// the file as the head reaches them, so the file must stay open while the
+// ring is used.
+func NewRingFromWav(file Reader) (*Ring, error) {
+	store, markers, err := openWav(file)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	return NewRing(store, markers), nil
+}
 
-	r.sampleRate = format.SampleRate
-	r.numChannels = format.NumChannels
-
-	for range r.numChannels {
-		r.buffers = append(r.buffers, make([]float64, 0))
+// NewRingFromFile returns a ring playing an audio file in any supported
+// format, told by its first bytes. The file must stay open while the ring is
+// used.
+func NewRingFromFile(file Reader) (*Ring, error) {
+	store, markers, err := OpenStore(file)
+	if err != nil {
+		return nil, err
 	}
+	return NewRing(store, markers), nil
+}
 
-	var samplesCount uint32
-	for {
-		samples, err := reader.ReadSamples()
-		if err == io.EOF {
-			break
-		} else if err != nil {
-			return err
-		}
-		for _, sample := range samples {
-			for ch := range r.numChannels {
-				r.buffers[ch] = append(r.buffers[ch], reader.FloatValue(sample, uint(ch)))
-			}
-			samplesCount++
-		}
+// sampleAt returns frame i of a channel, applying the boundary mode when i
+// is outside the region.
+func (r *Ring) sampleAt(i int, ch int) float64 {
+	i, ok := boundaryIndex(r.boundary, i-r.regionStart, r.regionEnd-r.regionStart)
+	if !ok {
+		return 0
 	}
-	r.samplesCount = samplesCount
-
-	r.headPositionFn = func(t float64) float64 { return t }
-
-	return nil
+	return r.store.Sample(r.regionStart+i, ch)
 }
 
 func (r *Ring) getSampleAtTimeLinear(t float64, ch int) float64 {
 	pos := t * float64(r.sampleRate)
-	n := float64(r.samplesCount)
-
-	pos = math.Mod(pos, n)
-	if pos < 0 {
-		pos += n
-	}
-
-	i0 := int(pos)
-	i1 := i0 + 1
-	if i1 >= int(r.samplesCount) {
-		i1 = 0
-	}
 
+	i0 := int(math.Floor(pos))
 	frac := pos - float64(i0)
-	s0 := r.buffers[ch][i0]
-	s1 := r.buffers[ch][i1]
+	s0 := r.sampleAt(i0, ch)
+	s1 := r.sampleAt(i0+1, ch)
 	return s0 + (s1-s0)*frac
 }
 
+// readFrame reads the frame under the head at the current time into frame.
+func (r *Ring) readFrame(headTime float64, frame []float64) {
+	switch r.quality {
+	case QualitySinc:
+		// The speed is in source frames per output frame, so that the filter
+		// also band-limits a conversion to a lower output rate
+		dt := r.speed / float64(r.outputRate)
+		speed := (r.headPositionFn(r.realTime+dt) - headTime) * float64(r.sampleRate)
+		r.readFrameSinc(headTime, speed, frame)
+	default:
+		for ch := range frame {
+			frame[ch] = r.getSampleAtTimeLinear(headTime, ch)
+		}
+	}
+}
+
 func (r *Ring) Read(buf []byte) (int, error) {
 	// reader MUST read float32 samples
 	if len(buf)%SizeofFloat32 != 0 {
@@ -106,12 +130,15 @@ func (r *Ring) Read(buf []byte) (int, error) {
 	bytesRequested := len(buf)
 	samplesRequested := bytesRequested / SizeofFloat32 / numChannels
 	bytesRead := 0
+	frame := make([]float64, numChannels)
 
 	for i := range samplesRequested {
 		headTime := r.headPositionFn(r.realTime)
+		gain := r.gainFn(r.realTime)
+		r.readFrame(headTime, frame)
 
 		for currentChannel := 0; currentChannel < numChannels; currentChannel++ {
-			sample := r.getSampleAtTimeLinear(headTime, currentChannel)
+			sample := frame[currentChannel] * gain
 
 			binary.LittleEndian.PutUint32(
 				buf[(i*numChannels+currentChannel)*SizeofFloat32:],
@@ -120,17 +147,97 @@ func (r *Ring) Read(buf []byte) (int, error) {
 			bytesRead += SizeofFloat32
 		}
 
-		r.realTime += 1.0 / float64(r.sampleRate)
+		r.realTime += r.speed / float64(r.outputRate)
 		if r.realTime > r.maxDuration {
 			return bytesRead, io.EOF
 		}
 	}
 
+	if err := r.store.Err(); err != nil {
+		return bytesRead, err
+	}
+
 	return bytesRead, nil
 }
 
+// SetSpeed sets how fast time runs, like the pitch control of a turntable:
+// the whole routine, tempo and pitch, plays speed times faster.
+func (r *Ring) SetSpeed(speed float64) { r.speed = speed }
+
+// Time returns the time reached in the routine, in seconds.
+func (r *Ring) Time() float64 { return r.realTime }
+
+// Reset moves playback back to the start, so that the ring can be read again
+// after it ended.
+func (r *Ring) Reset() { r.realTime = r.startTime }
+
+// Seek moves playback to an offset in bytes of the output read since the
+// start, implementing io.Seeker. Offsets count frames output at the current
+// speed, as Read does, and are rounded down to a whole frame. Seeking past the end is allowed; Read then
+// returns io.EOF.
+func (r *Ring) Seek(offset int64, whence int) (int64, error) {
+	var base float64
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		base = r.realTime - r.startTime
+	case io.SeekEnd:
+		base = r.maxDuration - r.startTime
+	default:
+		return 0, fmt.Errorf("%w: whence %d", ErrInvalidSeek, whence)
+	}
+
+	frameSize := int64(r.numChannels) * SizeofFloat32
+	position := int64(math.Round(base/r.speed*float64(r.outputRate)))*frameSize + offset
+	if position < 0 {
+		return 0, fmt.Errorf("%w: negative position %d", ErrInvalidSeek, position)
+	}
+	position -= position % frameSize
+	r.realTime = r.startTime + float64(position/frameSize)*r.speed/float64(r.outputRate)
+	return position, nil
+}
+
+// SeekTime moves playback to a time after the start.
+func (r *Ring) SeekTime(d time.Duration) error {
+	if d < 0 {
+		return fmt.Errorf("%w: negative time %s", ErrInvalidSeek, d)
+	}
+	r.realTime = r.startTime + d.Seconds()
+	return nil
+}
+
+// SetStart sets the time playback starts at after a reset, so that together
+// with SetDuration only part of the routine plays. It takes effect at the
+// next reset.
+func (r *Ring) SetStart(d time.Duration) { r.startTime = float64(d) / float64(time.Second) }
+
 // SetHeadPositionFn sets a function that returns the head position in seconds at a given time
 func (r *Ring) SetHeadPositionFn(fn func(float64) float64) { r.headPositionFn = fn }
-func (r *Ring) SetDuration(d time.Duration)                { r.maxDuration = float64(d) / float64(time.Second) }
-func (r *Ring) SampleRate() uint32                         { return r.sampleRate }
-func (r *Ring) NumChannels() int                           { return int(r.numChannels) }
+
+// SetGainFn sets a function that returns the output gain at a given time
+func (r *Ring) SetGainFn(fn func(float64) float64) { r.gainFn = fn }
+func (r *Ring) SetDuration(d time.Duration)        { r.maxDuration = float64(d) / float64(time.Second) }
+func (r *Ring) SetQuality(q Quality)               { r.quality = q }
+func (r *Ring) SetBoundary(b Boundary)             { r.boundary = b }
+
+// Duration returns the time playback ends at
+func (r *Ring) Duration() time.Duration { return time.Duration(r.maxDuration * float64(time.Second)) }
+
+// Start returns the time playback starts at after a reset
+func (r *Ring) Start() time.Duration { return time.Duration(r.startTime * float64(time.Second)) }
+
+// SetSampleRate sets the output sample rate, which defaults to the sample
+// rate of the source
+func (r *Ring) SetSampleRate(rate uint32) { r.outputRate = rate }
+
+// SampleRate returns the output sample rate
+func (r *Ring) SampleRate() uint32 { return r.outputRate }
+
+// SampleDuration returns the length of the sample played at normal speed
+func (r *Ring) SampleDuration() time.Duration {
+	return time.Duration(float64(r.frames) / float64(r.sampleRate) * float64(time.Second))
+}
+
+// SourceSampleRate returns the sample rate of the source
+func (r *Ring) SourceSampleRate() uint32 { return r.sampleRate }
+func (r *Ring) NumChannels() int         { return int(r.numChannels) }
@@ -0,0 +1,339 @@
+package ring
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSampleAtBoundaries(t *testing.T) {
+	ring := NewRing(NewMemoryStore(1, [][]float32{{1, 2, 3, 4}}), nil)
+
+	for boundary, expected := range map[Boundary][]float64{
+		// Frames -5 to 8
+		BoundaryWrap:     {4, 1, 2, 3, 4, 1, 2, 3, 4, 1, 2, 3, 4, 1},
+		BoundaryClamp:    {1, 1, 1, 1, 1, 1, 2, 3, 4, 4, 4, 4, 4, 4},
+		BoundarySilence:  {0, 0, 0, 0, 0, 1, 2, 3, 4, 0, 0, 0, 0, 0},
+		BoundaryPingPong: {2, 3, 4, 3, 2, 1, 2, 3, 4, 3, 2, 1, 2, 3},
+	} {
+		t.Run(string(boundary), func(t *testing.T) {
+			ring.SetBoundary(boundary)
+			actual := []float64{}
+			for i := -5; i <= 8; i++ {
+				actual = append(actual, ring.sampleAt(i, 0))
+			}
+			require.Equal(t, expected, actual)
+		})
+	}
+}
+
+func TestBoundaryIndexShortSamples(t *testing.T) {
+	for _, boundary := range []Boundary{BoundaryWrap, BoundaryClamp, BoundarySilence, BoundaryPingPong} {
+		t.Run(string(boundary), func(t *testing.T) {
+			for _, i := range []int{-3, -1, 0, 1, 5} {
+				_, ok := boundaryIndex(boundary, i, 0)
+				require.False(t, ok, i)
+
+				index, ok := boundaryIndex(boundary, i, 1)
+				if boundary == BoundarySilence && i != 0 {
+					require.False(t, ok, i)
+				} else {
+					require.True(t, ok, i)
+					require.Equal(t, 0, index, i)
+				}
+			}
+		})
+	}
+}
+
+func TestReadEmptySample(t *testing.T) {
+	for _, quality := range []Quality{QualityLinear, QualitySinc} {
+		ring := NewRing(NewMemoryStore(4, [][]float32{{}}), nil)
+		ring.SetQuality(quality)
+		ring.SetDuration(time.Second)
+		buf := make([]byte, 4*SizeofFloat32)
+		n, err := ring.Read(buf)
+		require.NoError(t, err)
+		require.Equal(t, make([]byte, n), buf[:n])
+	}
+}
+
+func TestSampleAtRegion(t *testing.T) {
+	ring := NewRing(NewMemoryStore(2, [][]float32{{1, 2, 3, 4, 5, 6}}), nil)
+	require.NoError(t, ring.SetRegion(0.5, 2.5))
+
+	for boundary, expected := range map[Boundary][]float64{
+		// Frames -1 to 7
+		BoundaryWrap:    {4, 5, 2, 3, 4, 5, 2, 3, 4},
+		BoundaryClamp:   {2, 2, 2, 3, 4, 5, 5, 5, 5},
+		BoundarySilence: {0, 0, 2, 3, 4, 5, 0, 0, 0},
+	} {
+		t.Run(string(boundary), func(t *testing.T) {
+			ring.SetBoundary(boundary)
+			actual := []float64{}
+			for i := -1; i <= 7; i++ {
+				actual = append(actual, ring.sampleAt(i, 0))
+			}
+			require.Equal(t, expected, actual)
+		})
+	}
+
+	require.ErrorIs(t, ring.SetRegion(2, 1), ErrInvalidRegion)
+	require.ErrorIs(t, ring.SetRegion(4, 5), ErrInvalidRegion)
+}
+
+type nopCloser struct {
+	*bytes.Reader
+}
+
+func (nopCloser) Close() error { return nil }
+
+func riffChunkBytes(id string, body []byte) []byte {
+	chunk := append([]byte(id), binary.LittleEndian.AppendUint32(nil, uint32(len(body)))...)
+	chunk = append(chunk, body...)
+	if len(body)%2 == 1 {
+		chunk = append(chunk, 0)
+	}
+	return chunk
+}
+
+func u32(values ...uint32) []byte {
+	b := []byte{}
+	for _, v := range values {
+		b = binary.LittleEndian.AppendUint32(b, v)
+	}
+	return b
+}
+
+func wavFormatChunk(format uint16, numChannels uint16, sampleRate uint32, bits uint16) []byte {
+	blockAlign := numChannels * bits / 8
+	body := binary.LittleEndian.AppendUint16(nil, format)
+	body = binary.LittleEndian.AppendUint16(body, numChannels)
+	body = append(body, u32(sampleRate, sampleRate*uint32(blockAlign))...)
+	body = binary.LittleEndian.AppendUint16(body, blockAlign)
+	body = binary.LittleEndian.AppendUint16(body, bits)
+	return riffChunkBytes("fmt ", body)
+}
+
+func wavFile(chunks ...[]byte) []byte {
+	body := []byte("WAVE")
+	for _, chunk := range chunks {
+		body = append(body, chunk...)
+	}
+	return riffChunkBytes("RIFF", body)
+}
+
+func TestWavStoreFormats(t *testing.T) {
+	for name, test := range map[string]struct {
+		format []byte
+		data   []byte
+	}{
+		"pcm8":  {wavFormatChunk(wavFormatPCM, 1, 8000, 8), []byte{0, 128, 192}},
+		"pcm16": {wavFormatChunk(wavFormatPCM, 1, 8000, 16), []byte{0x00, 0x80, 0, 0, 0x00, 0x40}},
+		"pcm24": {wavFormatChunk(wavFormatPCM, 1, 8000, 24), []byte{0, 0, 0x80, 0, 0, 0, 0, 0, 0x40}},
+		"float32": {
+			wavFormatChunk(wavFormatIEEEFloat, 1, 8000, 32),
+			binary.LittleEndian.AppendUint32(u32(math.Float32bits(-1), 0), math.Float32bits(0.5)),
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			store, err := NewWavStore(bytes.NewReader(wavFile(test.format, riffChunkBytes("data", test.data))))
+			require.NoError(t, err)
+			require.Equal(t, uint32(8000), store.SampleRate())
+			require.Equal(t, 1, store.NumChannels())
+			require.Equal(t, 3, store.Frames())
+			require.Equal(t, []float64{-1, 0, 0.5}, []float64{store.Sample(0, 0), store.Sample(1, 0), store.Sample(2, 0)})
+			require.NoError(t, store.Err())
+		})
+	}
+
+	_, err := NewWavStore(bytes.NewReader(wavFile(wavFormatChunk(2, 1, 8000, 16), riffChunkBytes("data", nil))))
+	require.ErrorIs(t, err, ErrUnsupportedFormat)
+
+	// A data chunk without a whole frame has nothing to play
+	for _, data := range [][]byte{nil, {0}} {
+		_, err = NewWavStore(bytes.NewReader(wavFile(wavFormatChunk(wavFormatPCM, 1, 8000, 16), riffChunkBytes("data", data))))
+		require.ErrorIs(t, err, ErrNoFrames)
+	}
+}
+
+func TestWavStoreChunks(t *testing.T) {
+	// Enough stereo frames to evict chunks from the cache
+	frames := pcmChunkFrames * (pcmCachedChunks + 2)
+	data := make([]byte, 0, frames*4)
+	for i := range frames {
+		data = binary.LittleEndian.AppendUint16(data, uint16(i))
+		data = binary.LittleEndian.AppendUint16(data, uint16(-i))
+	}
+	file := wavFile(wavFormatChunk(wavFormatPCM, 2, 44100, 16), riffChunkBytes("data", data))
+
+	store, err := NewWavStore(bytes.NewReader(file))
+	require.NoError(t, err)
+	require.Equal(t, frames, store.Frames())
+	for _, i := range []int{0, 1, frames - 1, pcmChunkFrames, 5, frames / 2, 3} {
+		require.Equal(t, float64(int16(i))/32768, store.Sample(i, 0))
+		require.Equal(t, float64(int16(-i))/32768, store.Sample(i, 1))
+	}
+	require.LessOrEqual(t, store.lru.Len(), pcmCachedChunks)
+
+	// A streamed file does not know the size of its data chunk
+	binary.LittleEndian.PutUint32(file[len(file)-len(data)-4:], 0xffffffff)
+	store, err = NewWavStore(bytes.NewReader(file))
+	require.NoError(t, err)
+	require.Equal(t, frames, store.Frames())
+	require.Equal(t, float64(int16(frames-1))/32768, store.Sample(frames-1, 0))
+}
+
+func TestMarkers(t *testing.T) {
+	const sampleRate = 100
+
+	cue := u32(3)
+	cue = append(cue, u32(1, 0, 0x61746164, 0, 0, 50)...)
+	cue = append(cue, u32(2, 0, 0x61746164, 0, 0, 120)...)
+	cue = append(cue, u32(7, 0, 0x61746164, 0, 0, 10)...)
+
+	adtl := []byte("adtl")
+	adtl = append(adtl, riffChunkBytes("labl", append(u32(1), "hit\x00"...))...)
+	adtl = append(adtl, riffChunkBytes("labl", append(u32(2), "scratch\x00"...))...)
+	adtl = append(adtl, riffChunkBytes("ltxt", u32(2, 30, 0, 0, 0))...)
+
+	smpl := u32(0, 0, 0, 60, 0, 0, 0, 1, 0)
+	smpl = append(smpl, u32(9, 0, 150, 199, 0, 0)...)
+
+	file := wavFile(
+		wavFormatChunk(wavFormatPCM, 1, sampleRate, 16),
+		riffChunkBytes("cue ", cue),
+		riffChunkBytes("data", make([]byte, 2*200)),
+		riffChunkBytes("LIST", adtl),
+		riffChunkBytes("smpl", smpl),
+	)
+
+	ring, err := NewRingFromWav(nopCloser{bytes.NewReader(file)})
+	require.NoError(t, err)
+	require.Equal(t, []Marker{
+		{Name: "hit", Start: 0.5, End: 0.5},
+		{Name: "scratch", Start: 1.2, End: 1.5},
+		{Name: "7", Start: 0.1, End: 0.1},
+		{Name: "loop1", Start: 1.5, End: 2},
+	}, ring.Markers())
+
+	marker, ok := ring.Marker("scratch")
+	require.True(t, ok)
+	require.Equal(t, 1.2, marker.Start)
+	_, ok = ring.Marker("missing")
+	require.False(t, ok)
+}
+
+func TestSampleRate(t *testing.T) {
+	ring := NewRing(NewMemoryStore(4, [][]float32{{0, 1, 2, 3, 4, 5, 6, 7}}), nil)
+	ring.SetDuration(time.Second)
+	ring.SetSampleRate(8)
+	require.Equal(t, uint32(8), ring.SampleRate())
+	require.Equal(t, uint32(4), ring.SourceSampleRate())
+
+	buf := make([]byte, 8*SizeofFloat32)
+	n, err := ring.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, len(buf), n)
+	actual := []float64{}
+	for i := range 8 {
+		actual = append(actual, float64(math.Float32frombits(binary.LittleEndian.Uint32(buf[i*SizeofFloat32:]))))
+	}
+	require.Equal(t, []float64{0, 0.5, 1, 1.5, 2, 2.5, 3, 3.5}, actual)
+}
+
+func TestSeek(t *testing.T) {
+	ring := NewRing(NewMemoryStore(4, [][]float32{{0, 1, 2, 3, 4, 5, 6, 7}}), nil)
+	ring.SetDuration(1500 * time.Millisecond)
+	ring.SetStart(250 * time.Millisecond)
+	ring.Reset()
+
+	read := func() []float64 {
+		buf := make([]byte, 2*SizeofFloat32)
+		n, err := ring.Read(buf)
+		require.NoError(t, err)
+		actual := []float64{}
+		for i := 0; i < n; i += SizeofFloat32 {
+			actual = append(actual, float64(math.Float32frombits(binary.LittleEndian.Uint32(buf[i:]))))
+		}
+		return actual
+	}
+
+	// Offsets count from the start, one frame to a 1/4 second
+	require.Equal(t, []float64{1, 2}, read())
+	position, err := ring.Seek(0, io.SeekCurrent)
+	require.NoError(t, err)
+	require.Equal(t, int64(2*SizeofFloat32), position)
+
+	position, err = ring.Seek(-2*SizeofFloat32-1, io.SeekEnd)
+	require.NoError(t, err)
+	require.Equal(t, int64(2*SizeofFloat32), position)
+	require.Equal(t, []float64{3, 4}, read())
+
+	position, err = ring.Seek(SizeofFloat32, io.SeekStart)
+	require.NoError(t, err)
+	require.Equal(t, int64(SizeofFloat32), position)
+	require.Equal(t, []float64{2, 3}, read())
+
+	require.NoError(t, ring.SeekTime(time.Second))
+	require.Equal(t, 1.25, ring.Time())
+	ring.Reset()
+	require.Equal(t, []float64{1, 2}, read())
+
+	_, err = ring.Seek(-1, io.SeekStart)
+	require.ErrorIs(t, err, ErrInvalidSeek)
+	_, err = ring.Seek(0, 3)
+	require.ErrorIs(t, err, ErrInvalidSeek)
+	require.ErrorIs(t, ring.SeekTime(-time.Second), ErrInvalidSeek)
+}
+
+func TestSeekSpeed(t *testing.T) {
+	ring := NewRing(NewMemoryStore(4, [][]float32{{0, 1, 2, 3, 4, 5, 6, 7}}), nil)
+	ring.SetDuration(2 * time.Second)
+
+	// Offsets count the frames read, whatever the speed
+	for _, speed := range []float64{2, 0.5} {
+		ring.SetSpeed(speed)
+		ring.Reset()
+		buf := make([]byte, 3*SizeofFloat32)
+		n, err := ring.Read(buf)
+		require.NoError(t, err)
+		position, err := ring.Seek(0, io.SeekCurrent)
+		require.NoError(t, err)
+		require.Equal(t, int64(n), position, "speed %g", speed)
+		require.InDelta(t, 3*speed/4, ring.Time(), 1e-9, "speed %g", speed)
+
+		_, err = ring.Seek(-SizeofFloat32, io.SeekCurrent)
+		require.NoError(t, err)
+		require.InDelta(t, 2*speed/4, ring.Time(), 1e-9, "speed %g", speed)
+		_, err = ring.Read(buf[:SizeofFloat32])
+		require.NoError(t, err)
+		require.Equal(t, float32(2*speed), math.Float32frombits(binary.LittleEndian.Uint32(buf)), "speed %g", speed)
+	}
+}
+
+func TestReadTempo(t *testing.T) {
+	acid := u32(0, 0x3c, 0, 8, 0x00040004, math.Float32bits(92.5))
+	file := wavFile(
+		wavFormatChunk(wavFormatPCM, 1, 100, 16),
+		riffChunkBytes("acid", acid),
+		riffChunkBytes("data", make([]byte, 2*10)),
+	)
+	tempo, err := ReadTempo(bytes.NewReader(file))
+	require.NoError(t, err)
+	require.Equal(t, 92.5, tempo)
+
+	file = wavFile(wavFormatChunk(wavFormatPCM, 1, 100, 16), riffChunkBytes("data", make([]byte, 2*10)))
+	tempo, err = ReadTempo(bytes.NewReader(file))
+	require.NoError(t, err)
+	require.Zero(t, tempo)
+
+	file = wavFile(riffChunkBytes("acid", acid[:8]))
+	_, err = ReadTempo(bytes.NewReader(file))
+	require.Error(t, err)
+}
@@ -0,0 +1,112 @@
+package ring
+
+import "math"
+
+const (
+	// sincZeroCrossings is the number of zero crossings of the kernel on each
+	// side of its centre when the head moves at normal speed or slower.
+	sincZeroCrossings = 16
+	// sincResolution is the number of precomputed kernel values per zero
+	// crossing; values in between are interpolated linearly.
+	sincResolution = 256
+	// sincRolloff puts the cutoff slightly below the output Nyquist
+	// frequency when the head moves faster than normal, so the transition
+	// band of the window stays out of the aliasing region.
+	sincRolloff = 0.94
+	// sincKaiserBeta sets the shape of the Kaiser window.
+	sincKaiserBeta = 8.6
+	// sincMaxStretch caps how wide the kernel gets for fast moves, and with
+	// it the cost of reading a frame.
+	sincMaxStretch = 32.0
+)
+
+// sincTable holds one side of the windowed-sinc kernel sampled
+// sincResolution times per zero crossing. Its cutoff is the Nyquist
+// frequency, so it is zero on every frame but the centre one.
+var sincTable = newSincTable()
+
+func newSincTable() []float64 {
+	n := sincZeroCrossings * sincResolution
+	table := make([]float64, n+1)
+	for i := range table {
+		x := float64(i) / sincResolution
+		table[i] = sinc(x) * kaiser(x/sincZeroCrossings, sincKaiserBeta)
+	}
+	return table
+}
+
+func sinc(x float64) float64 {
+	if x == 0 {
+		return 1
+	}
+	return math.Sin(math.Pi*x) / (math.Pi * x)
+}
+
+// kaiser returns the Kaiser window at x in [-1, 1].
+func kaiser(x, beta float64) float64 {
+	if x <= -1 || x >= 1 {
+		return 0
+	}
+	return besselI0(beta*math.Sqrt(1-x*x)) / besselI0(beta)
+}
+
+// besselI0 is the zeroth order modified Bessel function of the first kind.
+func besselI0(x float64) float64 {
+	sum, term := 1.0, 1.0
+	for k := 1; term > 1e-12*sum; k++ {
+		half := x / (2 * float64(k))
+		term *= half * half
+		sum += term
+	}
+	return sum
+}
+
+// sincKernel returns the kernel at x zero crossings from its centre.
+func sincKernel(x float64) float64 {
+	pos := math.Abs(x) * sincResolution
+	i := int(pos)
+	if i >= len(sincTable)-1 {
+		return 0
+	}
+	frac := pos - float64(i)
+	return sincTable[i] + (sincTable[i+1]-sincTable[i])*frac
+}
+
+// readFrameSinc reads the frame at time t into frame with a windowed-sinc
+// filter. speed is the number of source frames the head travels per output
+// frame; above 1 the kernel is stretched to lower the cutoff accordingly.
+// At normal speed or slower, frames read on their own position are the
+// samples themselves.
+func (r *Ring) readFrameSinc(t float64, speed float64, frame []float64) {
+	stretch := 1.0
+	if s := math.Abs(speed); s > 1 {
+		stretch = min(s/sincRolloff, sincMaxStretch)
+	}
+	pos := t * float64(r.sampleRate)
+	halfWidth := sincZeroCrossings * stretch
+
+	for ch := range frame {
+		frame[ch] = 0
+	}
+
+	first := int(math.Ceil(pos - halfWidth))
+	last := int(math.Floor(pos + halfWidth))
+	weights := 0.0
+	for i := first; i <= last; i++ {
+		weight := sincKernel((pos - float64(i)) / stretch)
+		if weight == 0 {
+			continue
+		}
+		weights += weight
+		for ch := range frame {
+			frame[ch] += weight * r.sampleAt(i, ch)
+		}
+	}
+
+	// Normalize so the filter has unity gain whatever the stretch
+	if weights != 0 {
+		for ch := range frame {
+			frame[ch] /= weights
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package ring
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const sincTestRate = 1000
+
+// sincRing returns a ring over a sound of sincTestRate frames.
+func sincRing(sample func(i int) float64) *Ring {
+	samples := make([]float32, sincTestRate)
+	for i := range samples {
+		samples[i] = float32(sample(i))
+	}
+	return NewRing(NewMemoryStore(sincTestRate, [][]float32{samples}), nil)
+}
+
+// sincPeak returns the loudest frame the filter reads between two frames at
+// a speed, away from the ends of the sound.
+func sincPeak(r *Ring, speed float64) float64 {
+	frame := []float64{0}
+	peak := 0.0
+	for i := 400; i < 600; i++ {
+		r.readFrameSinc((float64(i)+0.37)/sincTestRate, speed, frame)
+		peak = max(peak, math.Abs(frame[0]))
+	}
+	return peak
+}
+
+func TestSincUnityGain(t *testing.T) {
+	r := sincRing(func(int) float64 { return 0.5 })
+	frame := []float64{0}
+	for _, speed := range []float64{0, 0.3, 1, -1, 1.5, 2.7, -8, 100} {
+		for _, pos := range []float64{500, 500.25, 500.5, 500.99} {
+			r.readFrameSinc(pos/sincTestRate, speed, frame)
+			require.InDelta(t, 0.5, frame[0], 1e-6, "speed %g at frame %g", speed, pos)
+		}
+	}
+}
+
+func TestSincSamplePositions(t *testing.T) {
+	r := sincRing(func(i int) float64 { return math.Sin(float64(i)*0.7) * math.Cos(float64(i)*0.13) })
+	frame := []float64{0}
+	for _, speed := range []float64{1, 0.5, -1} {
+		for i := 100; i < 900; i++ {
+			r.readFrameSinc(float64(i)/sincTestRate, speed, frame)
+			require.InDelta(t, r.sampleAt(i, 0), frame[0], 1e-9, "speed %g at frame %d", speed, i)
+		}
+	}
+}
+
+func TestSincCutoff(t *testing.T) {
+	// A tone at 80% of the Nyquist frequency passes at normal speed, but
+	// would alias at twice the speed
+	r := sincRing(func(i int) float64 { return math.Sin(2 * math.Pi * 0.4 * float64(i)) })
+	require.InDelta(t, 1, sincPeak(r, 1), 0.01)
+	require.InDelta(t, 1, sincPeak(r, 0.5), 0.01)
+	require.Less(t, sincPeak(r, 2), 1e-3)
+	require.Less(t, sincPeak(r, -2), 1e-3)
+
+	// Below the lowered cutoff it still passes
+	r = sincRing(func(i int) float64 { return math.Sin(2 * math.Pi * 0.1 * float64(i)) })
+	require.InDelta(t, 1, sincPeak(r, 2), 0.01)
+}
@@ -0,0 +1,44 @@
+package ring
+
+// Store holds the frames of a sample. A store may decode its frames on
+// demand, in which case decoding errors are reported by Err rather than by
+// Sample.
+type Store interface {
+	SampleRate() uint32
+	NumChannels() int
+	// Frames returns the number of frames of the sample.
+	Frames() int
+	// Sample returns a sample of frame i, between -1 and 1.
+	Sample(i int, ch int) float64
+	// Err returns the first error met while decoding frames.
+	Err() error
+}
+
+// MemoryStore is a Store holding the whole decoded sample.
+type MemoryStore struct {
+	sampleRate uint32
+	channels   [][]float32
+}
+
+var _ Store = (*MemoryStore)(nil)
+
+// NewMemoryStore returns a store holding one slice of samples per channel.
+// All channels must have the same length.
+func NewMemoryStore(sampleRate uint32, channels [][]float32) *MemoryStore {
+	return &MemoryStore{sampleRate: sampleRate, channels: channels}
+}
+
+func (s *MemoryStore) SampleRate() uint32 { return s.sampleRate }
+func (s *MemoryStore) NumChannels() int   { return len(s.channels) }
+func (s *MemoryStore) Err() error         { return nil }
+
+func (s *MemoryStore) Frames() int {
+	if len(s.channels) == 0 {
+		return 0
+	}
+	return len(s.channels[0])
+}
+
+func (s *MemoryStore) Sample(i int, ch int) float64 {
+	return float64(s.channels[ch][i])
+}
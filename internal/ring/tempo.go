@@ -0,0 +1,50 @@
+package ring
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// acidChunkSize is the size of the "acid" chunk loop editors store in WAV
+// files, with the tempo of the loop as a float32 at acidTempoOffset.
+const (
+	acidChunkSize   = 24
+	acidTempoOffset = 20
+)
+
+// ReadTempo reads the tempo of a loop in bpm from the "acid" chunk of a WAV
+// file. It returns 0 when the file has none, including files of other
+// formats.
+func ReadTempo(r io.ReaderAt) (float64, error) {
+	format, err := SniffFormat(r)
+	if err != nil {
+		return 0, err
+	}
+	if format != FormatWav {
+		return 0, nil
+	}
+
+	chunks, err := readChunks(r)
+	if err != nil {
+		return 0, err
+	}
+	for _, chunk := range chunks {
+		if chunk.id != "acid" {
+			continue
+		}
+		body, err := chunk.read(r)
+		if err != nil {
+			return 0, err
+		}
+		if len(body) < acidChunkSize {
+			return 0, fmt.Errorf("%q chunk: %w", chunk.id, errTruncatedChunk)
+		}
+		tempo := float64(math.Float32frombits(binary.LittleEndian.Uint32(body[acidTempoOffset:])))
+		if tempo > 0 {
+			return tempo, nil
+		}
+	}
+	return 0, nil
+}
@@ -0,0 +1,134 @@
+package ring
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	wavFormatPCM        = 1
+	wavFormatIEEEFloat  = 3
+	wavFormatALaw       = 6
+	wavFormatMULaw      = 7
+	wavFormatExtensible = 0xfffe
+)
+
+var (
+	ErrUnsupportedFormat = errors.New("unsupported format")
+	// ErrNoFrames is the error of a file holding no whole frame, which
+	// would leave the ring nothing to play.
+	ErrNoFrames = errors.New("no audio frames")
+)
+
+// NewWavStore reads the format of a WAV file and returns a store decoding
+// its frames from r.
+func NewWavStore(r io.ReaderAt) (*PCMStore, error) {
+	s, _, err := newWavStore(r)
+	return s, err
+}
+
+// openWav opens a WAV file with its markers.
+func openWav(r io.ReaderAt) (Store, []Marker, error) {
+	store, chunks, err := newWavStore(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	markers, err := readMarkers(r, chunks, store.SampleRate())
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to read markers: %w", err)
+	}
+	return store, markers, nil
+}
+
+func newWavStore(r io.ReaderAt) (*PCMStore, []riffChunk, error) {
+	chunks, err := readChunks(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	header := make([]byte, 4)
+	if _, err := r.ReadAt(header, riffHeaderSize-4); err != nil {
+		return nil, nil, err
+	}
+	if string(header) != "WAVE" {
+		return nil, nil, fmt.Errorf("not a WAVE file")
+	}
+
+	s := newPCMStore(r)
+	var formatFound, dataFound bool
+	for _, chunk := range chunks {
+		switch chunk.id {
+		case "fmt ":
+			body, err := chunk.read(r)
+			if err != nil {
+				return nil, nil, err
+			}
+			if err := readWavFormat(s, body); err != nil {
+				return nil, nil, err
+			}
+			formatFound = true
+		case "data":
+			if dataFound {
+				continue
+			}
+			s.dataOffset = chunk.offset
+			s.frames = int(dataSize(r, chunk.offset, int64(chunk.size)))
+			dataFound = true
+		}
+	}
+	if !formatFound {
+		return nil, nil, fmt.Errorf("format chunk not found")
+	}
+	if !dataFound {
+		return nil, nil, fmt.Errorf("data chunk not found")
+	}
+	s.frames /= s.blockAlign()
+	if s.frames == 0 {
+		return nil, nil, fmt.Errorf("data chunk: %w", ErrNoFrames)
+	}
+
+	return s, chunks, nil
+}
+
+func readWavFormat(s *PCMStore, body []byte) error {
+	if len(body) < 16 {
+		return fmt.Errorf("%q chunk: %w", "fmt ", errTruncatedChunk)
+	}
+	format := binary.LittleEndian.Uint16(body[0:2])
+	s.numChannels = int(binary.LittleEndian.Uint16(body[2:4]))
+	s.sampleRate = binary.LittleEndian.Uint32(body[4:8])
+	blockAlign := int(binary.LittleEndian.Uint16(body[12:14]))
+	bits := int(binary.LittleEndian.Uint16(body[14:16]))
+	if format == wavFormatExtensible {
+		if len(body) < 26 {
+			return fmt.Errorf("%q chunk: %w", "fmt ", errTruncatedChunk)
+		}
+		// The format is the start of the sub-format GUID
+		format = binary.LittleEndian.Uint16(body[24:26])
+	}
+	if s.numChannels == 0 || s.sampleRate == 0 || blockAlign < s.numChannels {
+		return fmt.Errorf("invalid format: %d channels at %d Hz", s.numChannels, s.sampleRate)
+	}
+
+	// Samples may be stored in a container wider than their bit depth
+	s.width = blockAlign / s.numChannels
+	switch {
+	case format == wavFormatPCM && s.width == 1:
+		s.decode = decodeUnsigned8
+	case format == wavFormatPCM && s.width <= 4:
+		s.decode = decodeInt(s.width, binary.LittleEndian)
+	case format == wavFormatIEEEFloat && bits == 32:
+		s.decode = decodeFloat32(binary.LittleEndian)
+	case format == wavFormatIEEEFloat && bits == 64:
+		s.decode = decodeFloat64(binary.LittleEndian)
+	case format == wavFormatALaw && bits == 8:
+		s.decode = decodeALaw
+	case format == wavFormatMULaw && bits == 8:
+		s.decode = decodeMULaw
+	default:
+		return fmt.Errorf("%w: format %#x with %d bits per sample", ErrUnsupportedFormat, format, bits)
+	}
+
+	return nil
+}
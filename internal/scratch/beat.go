@@ -0,0 +1,102 @@
+package scratch
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/fruity-loozrz/go-scratchpad/internal/automation"
+	"github.com/fruity-loozrz/go-scratchpad/internal/keyframes"
+	"github.com/fruity-loozrz/go-scratchpad/internal/ring"
+)
+
+// Fit is how a backing beat follows the tempo of a routine.
+type Fit string
+
+const (
+	// FitRepitch plays the loop faster or slower, which changes its pitch as
+	// on a turntable.
+	FitRepitch Fit = "repitch"
+	// FitStretch keeps the pitch: each sixteenth note of the loop starts on
+	// time and is cut short or followed by a gap, as in a beat slicer.
+	FitStretch Fit = "stretch"
+
+	DefaultFit = FitRepitch
+)
+
+// ParseFit parses a fit name as used on the command line.
+func ParseFit(s string) (Fit, error) {
+	switch f := Fit(s); f {
+	case FitRepitch, FitStretch:
+		return f, nil
+	}
+	return "", fmt.Errorf("invalid fit %q (expected %q or %q)", s, FitRepitch, FitStretch)
+}
+
+const (
+	// tempoStep is the spacing in beats of the keyframes following the
+	// tempo of a routine, fine enough for tempo ramps.
+	tempoStep = 1.0 / 16
+	// slicesPerBeat is the number of slices of a stretched loop in a beat.
+	slicesPerBeat = 4
+	// sliceFade is the time in seconds slices fade in and out over, so that
+	// cuts do not click.
+	sliceFade = 0.002
+)
+
+// FollowTempo loops the sound, a loop at bpm, at the tempo of a program from
+// start to end, as played by the ring of a deck. It takes effect at the next
+// reset.
+func (s *Scratch) FollowTempo(program *automation.Program, bpm float64, fit Fit, start, end time.Duration) error {
+	if bpm <= 0 {
+		return fmt.Errorf("invalid loop tempo %g bpm", bpm)
+	}
+
+	// The beat reached at each time, up to a slice past the end so that it
+	// is never clamped
+	lastBeat := math.Floor(program.TimeBeat(end.Seconds())*slicesPerBeat+1) / slicesPerBeat
+	points := []keyframes.Keyframe{}
+	for i := 0; len(points) == 0 || points[len(points)-1].Value < lastBeat; i++ {
+		beat := float64(i) * tempoStep
+		points = append(points, keyframes.Keyframe{Time: program.BeatTime(beat), Value: beat})
+	}
+	beatAt, err := keyframes.NewKeyframeSequence(&keyframes.PiecewiseLinearPredictor{}, points)
+	if err != nil {
+		return fmt.Errorf("failed to create tempo sequence: %w", err)
+	}
+
+	r := s.Ring
+	r.SetBoundary(ring.BoundaryWrap)
+	r.ResetRegion()
+	r.SetStart(start)
+	r.SetDuration(end)
+
+	beatLength := 60 / bpm
+	switch fit {
+	case FitStretch:
+		// Slices start on the keyframes, which fall on every slice
+		sliceLength := beatLength / slicesPerBeat
+		step := int(1 / (tempoStep * slicesPerBeat))
+		slice := func(t float64) (start, next float64, k int) {
+			k = int(math.Floor(beatAt.ValueAtTime(t) * slicesPerBeat))
+			k = min(max(k, 0), (len(points)-1)/step-1)
+			return points[k*step].Time, points[(k+1)*step].Time, k
+		}
+		r.SetHeadPositionFn(func(t float64) float64 {
+			start, _, k := slice(t)
+			return float64(k)*sliceLength + t - start
+		})
+		r.SetGainFn(func(t float64) float64 {
+			start, next, _ := slice(t)
+			elapsed := t - start
+			fade := min(elapsed, next-t, sliceLength-elapsed) / sliceFade
+			return min(max(fade, 0), 1)
+		})
+	default:
+		r.SetHeadPositionFn(func(t float64) float64 {
+			return beatAt.ValueAtTime(t) * beatLength
+		})
+		r.SetGainFn(func(float64) float64 { return 1 })
+	}
+	return nil
+}
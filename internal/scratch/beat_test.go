@@ -0,0 +1,76 @@
+package scratch_test
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/fruity-loozrz/go-scratchpad/internal/automation"
+	"github.com/fruity-loozrz/go-scratchpad/internal/ring"
+	"github.com/fruity-loozrz/go-scratchpad/internal/scratch"
+
+	"github.com/stretchr/testify/require"
+)
+
+// loopRate is the sample rate of the test loop, two beats at 60 bpm whose
+// samples are their own position in the loop.
+const loopRate = 1000
+
+func followTempo(t *testing.T, routine string, fit scratch.Fit) (*automation.Program, []float64) {
+	program, err := automation.Parse(routine)
+	require.NoError(t, err)
+
+	samples := make([]float32, 2*loopRate)
+	for i := range samples {
+		samples[i] = float32(i) / float32(len(samples))
+	}
+	scr := scratch.NewScratch()
+	scr.Ring = ring.NewRing(ring.NewMemoryStore(loopRate, [][]float32{samples}), nil)
+	end := time.Duration(program.BeatTime(program.Beats()) * float64(time.Second))
+	require.NoError(t, scr.FollowTempo(program, 60, fit, 0, end))
+	scr.Reset()
+
+	buf, err := io.ReadAll(scr)
+	require.NoError(t, err)
+	frames := []float64{}
+	for i := 0; i < len(buf); i += 4 {
+		frames = append(frames, float64(math.Float32frombits(binary.LittleEndian.Uint32(buf[i:]))))
+	}
+	return program, frames
+}
+
+func TestFollowTempoRepitch(t *testing.T) {
+	// At 120 bpm the loop plays twice as fast
+	program, frames := followTempo(t, "bpm 120\n0\n+1\n+1\n+1", scratch.FitRepitch)
+	require.InDelta(t, program.BeatTime(program.Beats())*loopRate, len(frames), 1)
+	for i := 1; i < len(frames); i++ {
+		step := frames[i] - frames[i-1]
+		if step < 0 {
+			// The loop wraps around
+			continue
+		}
+		require.InDelta(t, 2.0/(2*loopRate), step, 1e-5, "frame %d", i)
+	}
+}
+
+func TestFollowTempoStretch(t *testing.T) {
+	// Each sixteenth note of the loop starts with its own beat, while the
+	// tempo ramps up from the tempo of the loop to twice it
+	program, frames := followTempo(t, "bpm 60 -> 120 over 4\n0\n+1\n+1\n+1\n+1\n+1", scratch.FitStretch)
+	require.Less(t, program.BeatTime(4), 4.0)
+
+	checked := 0
+	for k := 0; program.BeatTime(float64(k+1)/4) < program.BeatTime(program.Beats()); k++ {
+		start, next := program.BeatTime(float64(k)/4), program.BeatTime(float64(k+1)/4)
+		for i := int(math.Ceil(start*loopRate)) + 3; float64(i+3) < next*loopRate && i < len(frames); i++ {
+			// The loop plays at its own speed from the start of slice k,
+			// and keeps its pitch
+			position := float64(k)/4 + float64(i)/loopRate - start
+			require.InDelta(t, math.Mod(position, 2)/2, frames[i], 1e-3, "slice %d, frame %d", k, i)
+			checked++
+		}
+	}
+	require.Greater(t, checked, len(frames)/2)
+}
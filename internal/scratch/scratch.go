@@ -3,7 +3,12 @@ package scratch
 import (
 	"fmt"
 	"io"
+	"math"
 	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/fruity-loozrz/go-scratchpad/internal/automation"
 	"github.com/fruity-loozrz/go-scratchpad/internal/keyframes"
@@ -13,10 +18,32 @@ import (
 type Scratch struct {
 	*ring.Ring
 
-	automationReader io.ReadCloser
-	wavReader        ring.Reader
+	automationReader   io.ReadCloser
+	automationFileName string
+	wavReader          ring.Reader
+	// bpm is the tempo the automation starts at until it sets its own, or 0
+	// for the default one.
+	bpm float64
+	// boundary overrides the boundary mode of the automation when set.
+	boundary ring.Boundary
+	// interpolation overrides the interpolation type of the automation when
+	// set.
+	interpolation automation.InterpolationType
+	// from and to are the section of the automation played, in beats; to
+	// is 0 for the end.
+	from, to float64
+
+	mu sync.Mutex
+	// next is the automation loaded by Reload, waiting for a rewind.
+	next *routine
+	// current is the automation playing and time the time reached by Read,
+	// as float64 bits, for other goroutines to follow playback.
+	current atomic.Pointer[routine]
+	time    atomic.Uint64
 }
 
+var _ io.ReadSeeker = (*Scratch)(nil)
+
 func NewScratch() *Scratch {
 	return &Scratch{}
 }
@@ -29,6 +56,8 @@ func (s *Scratch) SetAutomationReader(source io.ReadCloser) error {
 	return nil
 }
 
+// SetWavReader sets the sound to scratch. Despite the name it may be a WAV,
+// AIFF or FLAC file; the format is told by its first bytes.
 func (s *Scratch) SetWavReader(source ring.Reader) error {
 	if s.wavReader != nil {
 		return fmt.Errorf("wav source already set")
@@ -42,9 +71,35 @@ func (s *Scratch) SetAutomationFileName(fileName string) error {
 	if err != nil {
 		return err
 	}
-	return s.SetAutomationReader(f)
+	if err := s.SetAutomationReader(f); err != nil {
+		f.Close()
+		return err
+	}
+	s.automationFileName = fileName
+	return nil
+}
+
+// SetRoutine sets the automation from a string rather than a file. Errors in
+// it are reported against name.
+func (s *Scratch) SetRoutine(name, routine string) error {
+	if err := s.SetAutomationReader(io.NopCloser(strings.NewReader(routine))); err != nil {
+		return err
+	}
+	s.automationFileName = name
+	return nil
+}
+
+// SetBpm sets the tempo the automation starts at, until it sets its own.
+func (s *Scratch) SetBpm(bpm float64) { s.bpm = bpm }
+
+// SetInterpolationType sets how the head moves between keyframes,
+// overriding the automation, from the next time it is compiled.
+func (s *Scratch) SetInterpolationType(interpolation automation.InterpolationType) {
+	s.interpolation = interpolation
 }
 
+// SetWavFileName opens the sound file to scratch, in any format accepted by
+// SetWavReader.
 func (s *Scratch) SetWavFileName(fileName string) error {
 	f, err := os.Open(fileName)
 	if err != nil {
@@ -53,40 +108,284 @@ func (s *Scratch) SetWavFileName(fileName string) error {
 	return s.SetWavReader(f)
 }
 
+// Init loads the sound and the automation. Without an automation source the
+// sound plays straight through once, as a backing track.
 func (s *Scratch) Init() error {
-	ring, err := ring.NewRingFromWav(s.wavReader)
+	r, err := ring.NewRingFromFile(s.wavReader)
 	if err != nil {
 		return fmt.Errorf("unable to create ring: %w", err)
 	}
-	s.Ring = ring
+	s.Ring = r
+
+	if s.automationReader == nil {
+		r.SetBoundary(ring.BoundarySilence)
+		r.SetDuration(r.SampleDuration())
+		return nil
+	}
 
 	automationString, err := io.ReadAll(s.automationReader)
 	if err != nil {
 		return fmt.Errorf("unable to read automation: %w", err)
 	}
 
-	program, err := automation.Parse(string(automationString))
+	routine, err := s.compile(string(automationString))
 	if err != nil {
-		return fmt.Errorf("unable to parse automation: %w", err)
+		return err
+	}
+	s.apply(routine)
+
+	return nil
+}
+
+// routine is an automation program ready to drive the ring.
+type routine struct {
+	program     *automation.Program
+	lines       []int
+	boundary    ring.Boundary
+	region      bool
+	regionStart float64
+	regionEnd   float64
+	head        *keyframes.KeyframeSequence
+	fader       *keyframes.KeyframeSequence
+}
+
+// compile parses an automation program and prepares it for the ring. It
+// only reads what never changes in the ring, so it is safe while the ring is
+// playing.
+func (s *Scratch) compile(automationString string) (*routine, error) {
+	program, lines, err := automation.ParseFileLines(s.automationFileName, automationString, s.bpm)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse automation: %w", err)
+	}
+	if s.interpolation != "" {
+		program.SetInterpolationType(s.interpolation)
+	}
+
+	marker := func(name string) (float64, float64, bool) {
+		marker, ok := s.Ring.Marker(name)
+		return marker.Start, marker.End, ok
+	}
+	if err := program.ResolveMarkers(marker); err != nil {
+		return nil, fmt.Errorf("unable to resolve markers: %w", err)
+	}
+
+	routine := &routine{program: program, lines: lines}
+	if program.Boundary != "" {
+		if routine.boundary, err = ring.ParseBoundary(program.Boundary); err != nil {
+			return nil, err
+		}
+	}
+	if start, end, ok := program.RegionTimes(); ok {
+		if err := s.Ring.CheckRegion(start, end); err != nil {
+			return nil, fmt.Errorf("unable to set region %.3fs-%.3fs: %w", start, end, err)
+		}
+		routine.region, routine.regionStart, routine.regionEnd = true, start, end
 	}
 
 	kfPoints := program.ToKeyframes()
-	kfSequence, err := keyframes.NewKeyframeSequence(program.Predictor, kfPoints)
+	routine.head, err = keyframes.NewKeyframeSequence(program.Predictor, kfPoints)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create keyframe sequence: %w", err)
+	}
+
+	routine.fader, err = keyframes.NewKeyframeSequence(&keyframes.PiecewiseLinearPredictor{}, program.FaderKeyframes())
 	if err != nil {
-		return fmt.Errorf("failed to create keyframe sequence: %w", err)
+		return nil, fmt.Errorf("failed to create fader sequence: %w", err)
+	}
+
+	return routine, nil
+}
+
+// apply makes a routine drive the ring.
+func (s *Scratch) apply(routine *routine) {
+	r := s.Ring
+
+	switch {
+	case s.boundary != "":
+		r.SetBoundary(s.boundary)
+	case routine.boundary != "":
+		r.SetBoundary(routine.boundary)
+	default:
+		r.SetBoundary(ring.DefaultBoundary)
+	}
+	r.ResetRegion()
+	if routine.region {
+		// Checked when compiled
+		_ = r.SetRegion(routine.regionStart, routine.regionEnd)
+	}
+
+	r.SetHeadPositionFn(
+		func(f float64) float64 {
+			return routine.head.ValueAtTime(f)
+		},
+	)
+
+	end := routine.head.Duration()
+	if s.to != 0 {
+		end = min(end, seconds(routine.program.BeatTime(s.to)))
 	}
+	r.SetDuration(end)
+	r.SetStart(min(seconds(routine.program.BeatTime(s.from)), end))
 
-	ring.SetHeadPositionFn(
+	r.SetGainFn(
 		func(f float64) float64 {
-			return kfSequence.ValueAtTime(f)
+			return routine.fader.ValueAtTime(f)
 		},
 	)
 
-	ring.SetDuration(kfSequence.Duration())
+	s.current.Store(routine)
+}
+
+// seconds converts seconds to a duration.
+func seconds(t float64) time.Duration { return time.Duration(t * float64(time.Second)) }
+
+// SetSection plays only the part of the automation from one beat to another,
+// to being 0 for the end, including after a reload. Playback moves to the
+// start of the section.
+func (s *Scratch) SetSection(from, to float64) error {
+	routine := s.current.Load()
+	if routine == nil {
+		return fmt.Errorf("no automation to play a section of")
+	}
+	if from < 0 || to < 0 || to != 0 && to <= from {
+		return fmt.Errorf("invalid section from beat %g to %g", from, to)
+	}
+	if beats := routine.program.Beats(); from >= beats {
+		return fmt.Errorf("section starts at beat %g, after the automation ends at beat %g", from, beats)
+	}
+
+	s.from, s.to = from, to
+	s.apply(routine)
+	s.Rewind()
+	return nil
+}
+
+// SetBoundary sets the boundary mode of the ring, overriding the one of the
+// automation, including after a reload.
+func (s *Scratch) SetBoundary(boundary ring.Boundary) {
+	s.boundary = boundary
+	s.Ring.SetBoundary(boundary)
+}
+
+// Reload reads the automation file again. The new automation takes over when
+// the scratch is next rewound; if it has errors, the current one stays.
+func (s *Scratch) Reload() error {
+	if s.automationFileName == "" {
+		return fmt.Errorf("automation was not read from a file")
+	}
+	automationString, err := os.ReadFile(s.automationFileName)
+	if err != nil {
+		return fmt.Errorf("unable to read automation: %w", err)
+	}
+	routine, err := s.compile(string(automationString))
+	if err != nil {
+		return err
+	}
 
+	s.mu.Lock()
+	s.next = routine
+	s.mu.Unlock()
 	return nil
 }
 
+// Rewind moves playback back to the start, switching to the automation
+// loaded by Reload, if any.
+func (s *Scratch) Rewind() {
+	s.mu.Lock()
+	routine := s.next
+	s.next = nil
+	s.mu.Unlock()
+
+	if routine != nil {
+		s.apply(routine)
+	}
+	s.Reset()
+}
+
+// Read reads frames from the ring, keeping track of the time reached.
+func (s *Scratch) Read(buf []byte) (int, error) {
+	n, err := s.Ring.Read(buf)
+	s.storeTime()
+	return n, err
+}
+
+// Seek moves playback to an offset in bytes of output, as ring.Ring.Seek
+// does, so that a scratch is an io.ReadSeeker. The sound is not decoded
+// again.
+func (s *Scratch) Seek(offset int64, whence int) (int64, error) {
+	position, err := s.Ring.Seek(offset, whence)
+	s.storeTime()
+	return position, err
+}
+
+// SeekTime moves playback to a time after the start.
+func (s *Scratch) SeekTime(d time.Duration) error {
+	err := s.Ring.SeekTime(d)
+	s.storeTime()
+	return err
+}
+
+// Reset moves playback back to the start, keeping the current automation.
+func (s *Scratch) Reset() {
+	s.Ring.Reset()
+	s.storeTime()
+}
+
+func (s *Scratch) storeTime() { s.time.Store(math.Float64bits(s.Ring.Time())) }
+
+// Time returns the time reached by Read, in seconds. Unlike the methods of
+// the ring it may be called while another goroutine reads.
+func (s *Scratch) Time() float64 {
+	return math.Float64frombits(s.time.Load())
+}
+
+// Position is where a routine is at some time.
+type Position struct {
+	// Beat is the beat of the routine, and Beats its length.
+	Beat  float64
+	Beats float64
+	// Bpm is the tempo at the beat, at normal speed.
+	Bpm float64
+	// Head is the position of the head in the sample, in seconds.
+	Head float64
+	// Move is the move being played, if Moving, and Line the line of the
+	// automation it is written on.
+	Move   automation.Move
+	Line   int
+	Moving bool
+}
+
+// Program returns the automation playing, or nil without automation. It may
+// be called while another goroutine reads.
+func (s *Scratch) Program() *automation.Program {
+	if routine := s.current.Load(); routine != nil {
+		return routine.program
+	}
+	return nil
+}
+
+// PositionAt returns where the routine playing is at a time in seconds, or
+// false when the scratch has no automation. It may be called while another
+// goroutine reads.
+func (s *Scratch) PositionAt(t float64) (Position, bool) {
+	routine := s.current.Load()
+	if routine == nil {
+		return Position{}, false
+	}
+
+	program := routine.program
+	position := Position{
+		Beat:  program.TimeBeat(t),
+		Beats: program.Beats(),
+		Head:  routine.head.ValueAtTime(t),
+	}
+	position.Bpm = program.BpmAt(position.Beat)
+	if i, ok := program.MoveAt(position.Beat); ok {
+		position.Move, position.Line, position.Moving = program.Moves[i], routine.lines[i], true
+	}
+	return position, true
+}
+
 func (s *Scratch) Close() error {
 	if s.automationReader != nil {
 		err := s.automationReader.Close()
@@ -0,0 +1,235 @@
+package session
+
+import (
+	"bytes"
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/fruity-loozrz/go-scratchpad/internal/automation"
+	"github.com/fruity-loozrz/go-scratchpad/internal/ring"
+	"github.com/fruity-loozrz/go-scratchpad/internal/scratch"
+	"github.com/fruity-loozrz/go-scratchpad/internal/wavout"
+	"gopkg.in/yaml.v3"
+)
+
+// File is a session loaded from a session file, with the output it asks for.
+type File struct {
+	Session
+	Output Output
+}
+
+// Output is where and how a session file is rendered. Empty settings keep
+// the defaults of the render command.
+type Output struct {
+	File     string
+	BitDepth wavout.BitDepth
+	Dither   bool
+}
+
+// sessionYAML is the YAML layout of a session file.
+type sessionYAML struct {
+	// Bpm, Quality and Boundary are the defaults of the decks
+	Bpm      float64    `yaml:"bpm"`
+	Quality  string     `yaml:"quality"`
+	Boundary string     `yaml:"boundary"`
+	Output   outputYAML `yaml:"output"`
+	Decks    []deckYAML `yaml:"decks"`
+	Beat     *beatYAML  `yaml:"beat"`
+}
+
+type outputYAML struct {
+	File       string `yaml:"file"`
+	BitDepth   string `yaml:"bit-depth"`
+	SampleRate uint32 `yaml:"sample-rate"`
+	Channels   int    `yaml:"channels"`
+	Dither     bool   `yaml:"dither"`
+}
+
+type deckYAML struct {
+	Sound         string   `yaml:"sound"`
+	Automation    string   `yaml:"automation"`
+	Routine       string   `yaml:"routine"`
+	Gain          *float64 `yaml:"gain"`
+	Pan           float64  `yaml:"pan"`
+	Quality       string   `yaml:"quality"`
+	Boundary      string   `yaml:"boundary"`
+	Bpm           float64  `yaml:"bpm"`
+	Interpolation string   `yaml:"interpolation"`
+}
+
+type beatYAML struct {
+	Sound string   `yaml:"sound"`
+	Bpm   float64  `yaml:"bpm"`
+	Fit   string   `yaml:"fit"`
+	Gain  *float64 `yaml:"gain"`
+}
+
+// IsFileName tells whether a file name is that of a session file rather than
+// a sound file.
+func IsFileName(fileName string) bool {
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".yaml", ".yml":
+		return true
+	}
+	return false
+}
+
+// LoadFile reads a session file. Relative paths in it are resolved against
+// the directory of the file.
+func LoadFile(fileName string) (*File, error) {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	file, err := ParseFile(data, filepath.Dir(fileName))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fileName, err)
+	}
+	return file, nil
+}
+
+// FromArgs returns the session of a command line: a session file given as
+// an argument, or a sound file with its automation file and pan, followed by
+// the decks of --deck settings.
+func FromArgs(args []string, automation string, pan float64, specs []string) (*File, error) {
+	if len(args) == 0 || !IsFileName(args[0]) {
+		decks, err := ParseDecks(args, automation, pan, specs)
+		if err != nil {
+			return nil, err
+		}
+		return &File{Session: Session{Decks: decks}}, nil
+	}
+
+	if automation != "" || pan != 0 {
+		return nil, fmt.Errorf("--automation and --pan do not apply to a session file")
+	}
+	file, err := LoadFile(args[0])
+	if err != nil {
+		return nil, err
+	}
+	for _, spec := range specs {
+		deck, err := ParseDeck(spec)
+		if err != nil {
+			return nil, err
+		}
+		file.Decks = append(file.Decks, deck)
+	}
+	return file, nil
+}
+
+// ParseFile parses the YAML of a session file, resolving relative paths
+// against dir.
+func ParseFile(data []byte, dir string) (*File, error) {
+	var schema sessionYAML
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&schema); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	if len(schema.Decks) == 0 {
+		return nil, ErrNoDecks
+	}
+
+	resolve := func(path string) string {
+		if path == "" || filepath.IsAbs(path) {
+			return path
+		}
+		return filepath.Join(dir, path)
+	}
+
+	file := &File{
+		Session: Session{
+			SampleRate:  schema.Output.SampleRate,
+			NumChannels: schema.Output.Channels,
+		},
+		Output: Output{
+			File:   resolve(schema.Output.File),
+			Dither: schema.Output.Dither,
+		},
+	}
+	if schema.Output.BitDepth != "" {
+		bitDepth, err := wavout.ParseBitDepth(schema.Output.BitDepth)
+		if err != nil {
+			return nil, fmt.Errorf("output: %w", err)
+		}
+		file.Output.BitDepth = bitDepth
+	}
+
+	for i, d := range schema.Decks {
+		if d.Sound == "" {
+			return nil, fmt.Errorf("deck %d has no sound", i+1)
+		}
+		if d.Automation != "" && d.Routine != "" {
+			return nil, fmt.Errorf("deck %d has both an automation file and a routine", i+1)
+		}
+
+		deck := Deck{
+			Sound:      resolve(d.Sound),
+			Automation: resolve(d.Automation),
+			Routine:    d.Routine,
+			Gain:       1,
+			Pan:        d.Pan,
+			Bpm:        d.Bpm,
+		}
+		if d.Gain != nil {
+			deck.Gain = *d.Gain
+		}
+		if deck.Bpm == 0 {
+			deck.Bpm = schema.Bpm
+		}
+		if deck.Bpm < 0 {
+			return nil, fmt.Errorf("deck %d: invalid bpm %g", i+1, deck.Bpm)
+		}
+		if quality := cmp.Or(d.Quality, schema.Quality); quality != "" {
+			q, err := ring.ParseQuality(quality)
+			if err != nil {
+				return nil, fmt.Errorf("deck %d: %w", i+1, err)
+			}
+			deck.Quality = q
+		}
+		if boundary := cmp.Or(d.Boundary, schema.Boundary); boundary != "" {
+			b, err := ring.ParseBoundary(boundary)
+			if err != nil {
+				return nil, fmt.Errorf("deck %d: %w", i+1, err)
+			}
+			deck.Boundary = b
+		}
+		if d.Interpolation != "" {
+			interpolation, err := automation.ParseInterpolationType(d.Interpolation)
+			if err != nil {
+				return nil, fmt.Errorf("deck %d: %w", i+1, err)
+			}
+			deck.Interpolation = interpolation
+		}
+
+		file.Decks = append(file.Decks, deck)
+	}
+
+	if b := schema.Beat; b != nil {
+		if b.Sound == "" {
+			return nil, fmt.Errorf("beat has no sound")
+		}
+		if b.Bpm < 0 {
+			return nil, fmt.Errorf("beat: invalid bpm %g", b.Bpm)
+		}
+		beat := NewBeat(resolve(b.Sound))
+		beat.Bpm = b.Bpm
+		if b.Gain != nil {
+			beat.Gain = *b.Gain
+		}
+		if b.Fit != "" {
+			fit, err := scratch.ParseFit(b.Fit)
+			if err != nil {
+				return nil, fmt.Errorf("beat: %w", err)
+			}
+			beat.Fit = fit
+		}
+		file.Beat = beat
+	}
+	return file, nil
+}
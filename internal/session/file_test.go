@@ -0,0 +1,189 @@
+package session_test
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/fruity-loozrz/go-scratchpad/internal/automation"
+	"github.com/fruity-loozrz/go-scratchpad/internal/ring"
+	"github.com/fruity-loozrz/go-scratchpad/internal/scratch"
+	"github.com/fruity-loozrz/go-scratchpad/internal/session"
+	"github.com/fruity-loozrz/go-scratchpad/internal/wavout"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseFile(t *testing.T) {
+	file, err := session.ParseFile([]byte(`
+bpm: 90
+quality: sinc
+output:
+  file: out.wav
+  bit-depth: 24
+  sample-rate: 48000
+  channels: 2
+  dither: true
+decks:
+  - sound: beat.wav
+    gain: 0.5
+  - sound: /samples/voice.wav
+    automation: figure.auto.txt
+    pan: -1
+    boundary: clamp
+    quality: linear
+    interpolation: monotone
+  - sound: voice.wav
+    bpm: 120
+    routine: |
+      0
+      +1
+beat:
+  sound: break.wav
+  bpm: 92
+  fit: stretch
+`), "sessions")
+	require.NoError(t, err)
+
+	require.Equal(t, session.Output{
+		File:     filepath.Join("sessions", "out.wav"),
+		BitDepth: wavout.BitDepth24,
+		Dither:   true,
+	}, file.Output)
+	require.Equal(t, uint32(48000), file.SampleRate)
+	require.Equal(t, 2, file.NumChannels)
+	require.Equal(t, []session.Deck{
+		{Sound: filepath.Join("sessions", "beat.wav"), Gain: 0.5, Quality: ring.QualitySinc, Bpm: 90},
+		{
+			Sound:         "/samples/voice.wav",
+			Automation:    filepath.Join("sessions", "figure.auto.txt"),
+			Gain:          1,
+			Pan:           -1,
+			Quality:       ring.QualityLinear,
+			Boundary:      ring.BoundaryClamp,
+			Bpm:           90,
+			Interpolation: automation.InterpolationTypeMonotone,
+		},
+		{Sound: filepath.Join("sessions", "voice.wav"), Routine: "0\n+1\n", Gain: 1, Quality: ring.QualitySinc, Bpm: 120},
+	}, file.Decks)
+	require.Equal(t, &session.Beat{Sound: filepath.Join("sessions", "break.wav"), Bpm: 92, Fit: scratch.FitStretch, Gain: 1}, file.Beat)
+}
+
+func TestParseFileErrors(t *testing.T) {
+	for _, input := range []string{
+		"",
+		"decks: []",
+		"decks:\n  - gain: 1",
+		"decks:\n  - sound: a.wav\n    gian: 1",
+		"decks:\n  - sound: a.wav\n    automation: a.txt\n    routine: '+1'",
+		"decks:\n  - sound: a.wav\n    quality: best",
+		"boundary: edge\ndecks:\n  - sound: a.wav",
+		"decks:\n  - sound: a.wav\n    interpolation: spline",
+		"output:\n  bit-depth: 8\ndecks:\n  - sound: a.wav",
+		"decks:\n  - sound: a.wav\n    bpm: -1",
+		"decks:\n  - sound: a.wav\nbeat:\n  bpm: 90",
+		"decks:\n  - sound: a.wav\nbeat:\n  sound: b.wav\n  fit: warp",
+	} {
+		_, err := session.ParseFile([]byte(input), ".")
+		require.Error(t, err, input)
+	}
+}
+
+func TestOpenRoutine(t *testing.T) {
+	sound := writeSound(t, "voice.wav", 0, 0.25, 0.5, 0.75)
+	file, err := session.ParseFile([]byte(`
+decks:
+  - sound: voice.wav
+    bpm: 6000
+    routine: |
+      interpolate linear
+      0
+      +1
+      +1
+`), filepath.Dir(sound))
+	require.NoError(t, err)
+
+	mixed, err := session.Open(file.Session)
+	require.NoError(t, err)
+	defer mixed.Close()
+	require.Equal(t, 1, mixed.NumChannels())
+
+	// A beat at 6000 bpm lasts one frame at 100 Hz, so the two beats end
+	// after a few frames rather than the 86 of the default tempo
+	buf, err := io.ReadAll(mixed)
+	require.NoError(t, err)
+	require.InDelta(t, 3, len(buf)/4, 1)
+
+	file.Decks[0].Routine = "0\nbpm fast\n+1"
+	_, err = session.Open(file.Session)
+	require.ErrorContains(t, err, "routine:2:")
+}
+
+func TestInterpolation(t *testing.T) {
+	ramp := []float32{}
+	for i := range 40 {
+		ramp = append(ramp, float32(i)/40)
+	}
+	sound := writeSound(t, "voice.wav", ramp...)
+	render := func(routine, interpolation string) []byte {
+		file, err := session.ParseFile(fmt.Appendf(nil, `
+decks:
+  - sound: voice.wav
+    bpm: 600
+    interpolation: %s
+    routine: |
+      %s
+`, interpolation, strings.ReplaceAll(routine, "\n", "\n      ")), filepath.Dir(sound))
+		require.NoError(t, err)
+		mixed, err := session.Open(file.Session)
+		require.NoError(t, err)
+		defer mixed.Close()
+		buf, err := io.ReadAll(mixed)
+		require.NoError(t, err)
+		return buf
+	}
+
+	// The deck overrides the interpolation of its routine
+	cubic := render("interpolate cubic\n0\n+1\n-1/2\n+1", "''")
+	linear := render("interpolate linear\n0\n+1\n-1/2\n+1", "''")
+	require.NotEqual(t, cubic, linear)
+	require.Equal(t, linear, render("interpolate cubic\n0\n+1\n-1/2\n+1", "linear"))
+	require.Equal(t, cubic, render("interpolate linear\n0\n+1\n-1/2\n+1", "cubic"))
+}
+
+func TestLoopReload(t *testing.T) {
+	sound := writeSound(t, "voice.wav", 0, 0.25, 0.5, 0.75)
+	automation := filepath.Join(filepath.Dir(sound), "a.auto.txt")
+	require.NoError(t, os.WriteFile(automation, []byte("bpm 6000\ninterpolate linear\n0\n+1\n+1"), 0o644))
+
+	mixed, err := session.Open(session.Session{Decks: []session.Deck{session.NewDeck(sound, automation)}})
+	require.NoError(t, err)
+	defer mixed.Close()
+	mixed.SetLoop(true)
+
+	read := func(frames int) []float32 {
+		buf := make([]byte, 4*frames)
+		_, err := io.ReadFull(mixed, buf)
+		require.NoError(t, err)
+		samples := []float32{}
+		for i := 0; i < len(buf); i += 4 {
+			samples = append(samples, math.Float32frombits(binary.LittleEndian.Uint32(buf[i:])))
+		}
+		return samples
+	}
+	require.Equal(t, []float32{0, 0, 0.25, 0.5, 0, 0}, read(6))
+
+	// A broken routine keeps the current one playing
+	require.NoError(t, os.WriteFile(automation, []byte("bpm fast\n+1"), 0o644))
+	require.Error(t, mixed.Reload())
+
+	// A new routine takes over at the end of the loop
+	require.NoError(t, os.WriteFile(automation, []byte("bpm 6000\ninterpolate linear\n0.02\n-1\n-1"), 0o644))
+	require.NoError(t, mixed.Reload())
+	require.InDeltaSlice(t, []float32{0.25, 0.5, 0.005, 0.005, 0.735, 0.505}, read(6), 1e-6)
+}
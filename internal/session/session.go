@@ -0,0 +1,623 @@
+// Package session mixes several decks, each a sound with its own automation
+// or a backing track played straight, into one output.
+package session
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fruity-loozrz/go-scratchpad/internal/automation"
+	"github.com/fruity-loozrz/go-scratchpad/internal/click"
+	"github.com/fruity-loozrz/go-scratchpad/internal/mix"
+	"github.com/fruity-loozrz/go-scratchpad/internal/ring"
+	"github.com/fruity-loozrz/go-scratchpad/internal/scratch"
+)
+
+var ErrNoDecks = errors.New("session has no decks")
+
+// Deck is a sound on one deck of a session.
+type Deck struct {
+	Sound string
+	// Automation is the automation file scratching the sound, and Routine
+	// the automation itself when it is given inline. Without either the
+	// sound plays straight through, as a backing track.
+	Automation string
+	Routine    string
+	Gain       float64
+	// Pan places the deck between -1 (left) and 1 (right).
+	Pan float64
+	// Quality and Boundary default to those of the ring and the automation.
+	Quality  ring.Quality
+	Boundary ring.Boundary
+	// Bpm is the tempo the automation starts at until it sets its own, or 0
+	// for the default one.
+	Bpm float64
+	// Interpolation overrides the interpolation type of the automation when
+	// set.
+	Interpolation automation.InterpolationType
+}
+
+// scratched tells whether the deck has automation.
+func (d Deck) scratched() bool { return d.Automation != "" || d.Routine != "" }
+
+// NewDeck returns a deck at unity gain, panned to the center.
+func NewDeck(sound, automation string) Deck {
+	return Deck{Sound: sound, Automation: automation, Gain: 1}
+}
+
+// ParseDeck parses a deck as used on the command line: comma-separated
+// key=value settings, e.g. "sound=beat.wav,gain=0.8,pan=-0.5". The keys are
+// sound (required), automation, gain and pan.
+func ParseDeck(spec string) (Deck, error) {
+	deck := NewDeck("", "")
+	for field := range strings.SplitSeq(spec, ",") {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok {
+			return Deck{}, fmt.Errorf("invalid deck setting %q (expected key=value)", field)
+		}
+
+		var err error
+		switch key {
+		case "sound":
+			deck.Sound = value
+		case "automation":
+			deck.Automation = value
+		case "gain":
+			deck.Gain, err = strconv.ParseFloat(value, 64)
+		case "pan":
+			deck.Pan, err = strconv.ParseFloat(value, 64)
+		default:
+			return Deck{}, fmt.Errorf("unknown deck setting %q (expected sound, automation, gain or pan)", key)
+		}
+		if err != nil {
+			return Deck{}, fmt.Errorf("invalid deck %s %q", key, value)
+		}
+	}
+
+	if deck.Sound == "" {
+		return Deck{}, fmt.Errorf("deck %q has no sound", spec)
+	}
+	return deck, nil
+}
+
+// ParseDecks returns the decks of a command line: the sound file given as an
+// argument with its automation file and pan, then the decks parsed from
+// --deck settings.
+func ParseDecks(args []string, automation string, pan float64, specs []string) ([]Deck, error) {
+	var decks []Deck
+	if len(args) == 1 {
+		if automation == "" {
+			return nil, fmt.Errorf("required flag \"automation\" not set")
+		}
+		deck := NewDeck(args[0], automation)
+		deck.Pan = pan
+		decks = append(decks, deck)
+	} else if automation != "" || pan != 0 {
+		return nil, fmt.Errorf("--automation and --pan need a sound file")
+	}
+
+	for _, spec := range specs {
+		deck, err := ParseDeck(spec)
+		if err != nil {
+			return nil, err
+		}
+		decks = append(decks, deck)
+	}
+	if len(decks) == 0 {
+		return nil, fmt.Errorf("%w: give a sound file or --deck", ErrNoDecks)
+	}
+	return decks, nil
+}
+
+// Session is a set of decks mixed together.
+type Session struct {
+	Decks []Deck
+	// Quality applies to all decks and Boundary to the scratched ones,
+	// overriding the settings of each deck.
+	Quality  ring.Quality
+	Boundary ring.Boundary
+	// SampleRate defaults to the sample rate of the first deck.
+	SampleRate uint32
+	// NumChannels defaults to the most channels of any deck, and to at
+	// least stereo when there are several decks or a deck is panned.
+	NumChannels int
+	// From and To play only a section of the decks with automation, in
+	// beats; To is 0 for the end. Decks without automation play from their
+	// start.
+	From, To float64
+	// Loop is how many times the mix plays, or LoopForever; 0 plays it
+	// once.
+	Loop int
+	// Click mixes in a metronome on the beats of the lead deck, in
+	// TimeSignature or 4/4. ClickOnly leaves the decks out of the mix, for
+	// a click stem.
+	Click         bool
+	ClickOnly     bool
+	TimeSignature click.TimeSignature
+	// Beat is a drum loop played under the decks, or nil.
+	Beat *Beat
+}
+
+// Beat is a drum loop looped under the decks at the tempo of the lead deck.
+type Beat struct {
+	Sound string
+	// Bpm is the tempo of the loop, or 0 to read it from the "acid" chunk
+	// of the file or from its name, as in "break-92bpm.wav".
+	Bpm  float64
+	Fit  scratch.Fit
+	Gain float64
+}
+
+// NewBeat returns a beat repitched to the tempo, at unity gain.
+func NewBeat(sound string) *Beat {
+	return &Beat{Sound: sound, Fit: scratch.DefaultFit, Gain: 1}
+}
+
+// bpmInName finds a tempo in a file name, as in "break-92bpm.wav" or
+// "Funky Drummer 101 BPM.wav".
+var bpmInName = regexp.MustCompile(`(?i)(\d+(?:\.\d+)?)[ _-]?bpm`)
+
+// loopTempo returns the tempo of a loop, read from the file or its name.
+func loopTempo(fileName string) (float64, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	tempo, err := ring.ReadTempo(f)
+	if err != nil || tempo != 0 {
+		return tempo, err
+	}
+
+	if match := bpmInName.FindStringSubmatch(filepath.Base(fileName)); match != nil {
+		return strconv.ParseFloat(match[1], 64)
+	}
+	return 0, fmt.Errorf("unknown tempo: tag the file or name it like break-92bpm.wav, or give the bpm of the beat")
+}
+
+// LoopForever is the Loop of a session played until it is stopped.
+const LoopForever = -1
+
+// ParseLoop parses how many times to play a mix: a positive count or
+// "forever".
+func ParseLoop(loop string) (int, error) {
+	if loop == "forever" {
+		return LoopForever, nil
+	}
+	count, err := strconv.Atoi(loop)
+	if err != nil || count < 1 {
+		return 0, fmt.Errorf("invalid loop %q (expected a positive count or forever)", loop)
+	}
+	return count, nil
+}
+
+// Mix is the mixed output of an open session, read as float32 LE frames.
+type Mix struct {
+	*mix.Mixer
+
+	sampleRate uint32
+	decks      []Deck
+	scratches  []*scratch.Scratch
+	click      *click.Track
+	loop       atomic.Bool
+	// beat plays under the decks as set by beatSettings, which holds its
+	// tempo.
+	beat         *scratch.Scratch
+	beatSettings Beat
+	// plays is how many times the mix plays before it ends unless it loops,
+	// and played how many times it ended so far.
+	plays  int
+	played int
+
+	// speed is the speed the decks play at, and elapsed the time reached in
+	// the current pass at normal speed, in seconds as float64 bits.
+	speed   float64
+	elapsed atomic.Uint64
+
+	// Requests from other goroutines, applied by Read
+	mu       sync.Mutex
+	seeking  bool
+	seekTime float64
+	newSpeed float64
+	// section is the section of the decks to play from now on, if any.
+	section *[2]float64
+}
+
+var _ io.ReadSeeker = (*Mix)(nil)
+
+// Open loads the decks of a session and returns their mix.
+func Open(s Session) (*Mix, error) {
+	if len(s.Decks) == 0 {
+		return nil, ErrNoDecks
+	}
+
+	m := &Mix{decks: s.Decks, speed: 1}
+	for i, deck := range s.Decks {
+		scr, err := openDeck(deck)
+		if err != nil {
+			m.Close()
+			return nil, fmt.Errorf("deck %d (%s): %w", i+1, deck.Sound, err)
+		}
+		m.scratches = append(m.scratches, scr)
+	}
+	if s.Beat != nil {
+		if err := m.openBeat(*s.Beat); err != nil {
+			m.Close()
+			return nil, fmt.Errorf("beat (%s): %w", s.Beat.Sound, err)
+		}
+	}
+
+	m.sampleRate = s.SampleRate
+	if m.sampleRate == 0 {
+		m.sampleRate = m.scratches[0].SourceSampleRate()
+	}
+	numChannels := s.NumChannels
+	if numChannels == 0 {
+		for i, scr := range m.scratches {
+			numChannels = max(numChannels, scr.NumChannels())
+			if len(m.scratches) > 1 || s.Decks[i].Pan != 0 {
+				numChannels = max(numChannels, 2)
+			}
+		}
+		if m.beat != nil {
+			numChannels = max(numChannels, m.beat.NumChannels(), 2)
+		}
+	}
+
+	m.Mixer = mix.NewMixer(numChannels)
+	for i, scr := range m.scratches {
+		deck := s.Decks[i]
+		scr.SetSampleRate(m.sampleRate)
+		if quality := cmp.Or(s.Quality, deck.Quality); quality != "" {
+			scr.SetQuality(quality)
+		}
+		if boundary := cmp.Or(s.Boundary, deck.Boundary); boundary != "" && deck.scratched() {
+			scr.SetBoundary(boundary)
+		}
+		if (s.From != 0 || s.To != 0) && deck.scratched() {
+			if err := scr.SetSection(s.From, s.To); err != nil {
+				m.Close()
+				return nil, fmt.Errorf("deck %d (%s): %w", i+1, deck.Sound, err)
+			}
+		}
+		if s.ClickOnly {
+			continue
+		}
+		if err := m.Add(scr, scr.NumChannels(), deck.Gain, deck.Pan); err != nil {
+			m.Close()
+			return nil, fmt.Errorf("deck %d (%s): %w", i+1, deck.Sound, err)
+		}
+	}
+
+	if s.Click || s.ClickOnly || m.beat != nil {
+		if _, _, ok := m.Lead(); !ok {
+			m.Close()
+			return nil, fmt.Errorf("the click and the beat need a deck with automation to follow")
+		}
+	}
+	if m.beat != nil {
+		m.beat.SetSampleRate(m.sampleRate)
+		if s.Quality != "" {
+			m.beat.SetQuality(s.Quality)
+		}
+		if !s.ClickOnly {
+			if err := m.Add(m.beat, m.beat.NumChannels(), m.beatSettings.Gain, 0); err != nil {
+				m.Close()
+				return nil, fmt.Errorf("beat (%s): %w", m.beatSettings.Sound, err)
+			}
+		}
+	}
+	if s.Click || s.ClickOnly {
+		m.click = click.NewTrack(cmp.Or(s.TimeSignature, click.DefaultTimeSignature), m.sampleRate)
+		if err := m.Add(m.click, 1, 1, 0); err != nil {
+			m.Close()
+			return nil, fmt.Errorf("click: %w", err)
+		}
+	}
+	if err := m.followLead(); err != nil {
+		m.Close()
+		return nil, fmt.Errorf("beat (%s): %w", m.beatSettings.Sound, err)
+	}
+
+	m.plays = s.Loop
+	m.SetLoop(s.Loop == LoopForever)
+	return m, nil
+}
+
+// openBeat loads the beat of the session, which plays straight until it
+// follows the lead deck.
+func (m *Mix) openBeat(beat Beat) error {
+	if beat.Bpm == 0 {
+		bpm, err := loopTempo(beat.Sound)
+		if err != nil {
+			return err
+		}
+		beat.Bpm = bpm
+	}
+	if beat.Bpm <= 0 {
+		return fmt.Errorf("invalid tempo %g bpm", beat.Bpm)
+	}
+
+	scr, err := openDeck(Deck{Sound: beat.Sound})
+	if err != nil {
+		return err
+	}
+	m.beat, m.beatSettings = scr, beat
+	return nil
+}
+
+func openDeck(deck Deck) (*scratch.Scratch, error) {
+	scr := scratch.NewScratch()
+	if err := scr.SetWavFileName(deck.Sound); err != nil {
+		return nil, err
+	}
+	var err error
+	switch {
+	case deck.Routine != "":
+		err = scr.SetRoutine("routine", deck.Routine)
+	case deck.Automation != "":
+		err = scr.SetAutomationFileName(deck.Automation)
+	}
+	if err != nil {
+		scr.Close()
+		return nil, err
+	}
+	scr.SetBpm(deck.Bpm)
+	scr.SetInterpolationType(deck.Interpolation)
+	if err := scr.Init(); err != nil {
+		scr.Close()
+		return nil, err
+	}
+	return scr, nil
+}
+
+// SetLoop makes the mix start again from the beginning once all decks
+// ended, rather than end, however many times the session asked it to play.
+// It may be called while another goroutine reads.
+func (m *Mix) SetLoop(loop bool) { m.loop.Store(loop) }
+
+// Loop tells whether the mix loops.
+func (m *Mix) Loop() bool { return m.loop.Load() }
+
+// Seek moves all decks to an offset in bytes of the mix played at the current
+// speed, implementing io.Seeker so that an oto player can seek the mix. The
+// end of a mix is not known, so offsets from io.SeekEnd are errors. Seek may
+// be called while another goroutine reads: the decks move at the next read,
+// and the mix plays as many times again as the session asked.
+func (m *Mix) Seek(offset int64, whence int) (int64, error) {
+	frameSize := int64(m.NumChannels()) * ring.SizeofFloat32
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	// Times count at normal speed, and bytes at the speed the mix plays
+	rate := float64(m.sampleRate) / m.speed
+	var base float64
+	switch {
+	case whence == io.SeekStart:
+	case whence == io.SeekCurrent && m.seeking:
+		base = m.seekTime
+	case whence == io.SeekCurrent:
+		base = math.Float64frombits(m.elapsed.Load())
+	default:
+		return 0, fmt.Errorf("%w: whence %d", ring.ErrInvalidSeek, whence)
+	}
+
+	position := int64(math.Round(base*rate))*frameSize + offset
+	if position < 0 {
+		return 0, fmt.Errorf("%w: negative position %d", ring.ErrInvalidSeek, position)
+	}
+	position -= position % frameSize
+	m.seeking, m.seekTime = true, float64(position/frameSize)/rate
+	return position, nil
+}
+
+// SetSection plays only the part of the decks with automation from one beat
+// to another, to being 0 for the end, like Session.From and Session.To. All
+// decks start again from the next read. It may be called while another
+// goroutine reads.
+func (m *Mix) SetSection(from, to float64) error {
+	if from < 0 || to < 0 || to != 0 && to <= from {
+		return fmt.Errorf("invalid section from beat %g to %g", from, to)
+	}
+	for i, scr := range m.scratches {
+		if !m.decks[i].scratched() {
+			continue
+		}
+		if beats := scr.Program().Beats(); from >= beats {
+			return fmt.Errorf("deck %d (%s): section starts at beat %g, after the automation ends at beat %g",
+				i+1, m.decks[i].Sound, from, beats)
+		}
+	}
+
+	m.mu.Lock()
+	m.section = &[2]float64{from, to}
+	m.mu.Unlock()
+	return nil
+}
+
+// SetSpeed sets how fast all decks play from the next read, like the pitch
+// control of a turntable. It may be called while another goroutine reads.
+func (m *Mix) SetSpeed(speed float64) {
+	m.mu.Lock()
+	m.newSpeed = speed
+	m.mu.Unlock()
+}
+
+// Read reads mixed frames as float32 LE samples.
+func (m *Mix) Read(buf []byte) (int, error) {
+	m.mu.Lock()
+	seeking, seekTime, speed, section := m.seeking, m.seekTime, m.newSpeed, m.section
+	m.seeking, m.newSpeed, m.section = false, 0, nil
+	if speed != 0 {
+		// Seek reads the speed too
+		m.speed = speed
+	}
+	m.mu.Unlock()
+	if speed != 0 {
+		for _, scr := range m.scratches {
+			scr.SetSpeed(speed)
+		}
+		if m.click != nil {
+			m.click.SetSpeed(speed)
+		}
+		if m.beat != nil {
+			m.beat.SetSpeed(speed)
+		}
+	}
+	if section != nil {
+		for i, scr := range m.scratches {
+			if m.decks[i].scratched() {
+				// Checked by SetSection
+				_ = scr.SetSection(section[0], section[1])
+			}
+		}
+		m.rewind()
+		m.played = 0
+	}
+	if seeking {
+		m.seek(seekTime)
+	}
+
+	n, err := m.Mixer.Read(buf)
+	m.advance(n)
+	for err == io.EOF && m.again() {
+		m.rewind()
+		if n > 0 {
+			return n, nil
+		}
+		n, err = m.Mixer.Read(buf)
+		m.advance(n)
+	}
+	return n, err
+}
+
+// advance adds the time of n bytes read to the time elapsed.
+func (m *Mix) advance(n int) {
+	frames := n / (m.NumChannels() * ring.SizeofFloat32)
+	elapsed := math.Float64frombits(m.elapsed.Load())
+	m.elapsed.Store(math.Float64bits(elapsed + float64(frames)*m.speed/float64(m.sampleRate)))
+}
+
+// seek moves all decks to a time in seconds after their start.
+func (m *Mix) seek(t float64) {
+	d := time.Duration(t * float64(time.Second))
+	for _, scr := range m.scratches {
+		// Never fails as the time is positive
+		_ = scr.SeekTime(d)
+	}
+	if m.click != nil {
+		_ = m.click.SeekTime(d)
+	}
+	if m.beat != nil {
+		_ = m.beat.SeekTime(d)
+	}
+	m.Mixer.Rewind()
+	m.played = 0
+	m.elapsed.Store(math.Float64bits(t))
+}
+
+// again tells whether the mix plays again once it ended.
+func (m *Mix) again() bool {
+	if m.loop.Load() {
+		return true
+	}
+	m.played++
+	return m.played < m.plays
+}
+
+// rewind moves all decks back to the start. Decks switch to reloaded
+// automation here, so that a change never cuts into a routine.
+func (m *Mix) rewind() {
+	for _, scr := range m.scratches {
+		scr.Rewind()
+	}
+	// Succeeded when opened, and the reloaded automation only changes tempo
+	_ = m.followLead()
+	m.Mixer.Rewind()
+	m.elapsed.Store(0)
+}
+
+// followLead makes the click and the beat follow the automation of the lead
+// deck, which may have been reloaded, and moves them back to the start.
+func (m *Mix) followLead() error {
+	_, lead, ok := m.Lead()
+	if !ok {
+		return nil
+	}
+	if m.click != nil {
+		m.click.Follow(lead.Program(), lead.Start(), lead.Duration())
+		m.click.Reset()
+	}
+	if m.beat != nil {
+		beat := m.beatSettings
+		if err := m.beat.FollowTempo(lead.Program(), beat.Bpm, beat.Fit, lead.Start(), lead.Duration()); err != nil {
+			return err
+		}
+		m.beat.Reset()
+	}
+	return nil
+}
+
+// Lead returns the first deck with automation, which sets the beat of the
+// mix, and its scratch.
+func (m *Mix) Lead() (Deck, *scratch.Scratch, bool) {
+	for i, deck := range m.decks {
+		if deck.scratched() {
+			return deck, m.scratches[i], true
+		}
+	}
+	return Deck{}, nil, false
+}
+
+// AutomationFiles returns the automation files of the decks.
+func (m *Mix) AutomationFiles() []string {
+	var fileNames []string
+	for _, deck := range m.decks {
+		if deck.Automation != "" && deck.Routine == "" {
+			fileNames = append(fileNames, deck.Automation)
+		}
+	}
+	return fileNames
+}
+
+// Reload reads the automation files of the decks again. The new automation
+// takes over when the mix next loops; decks whose automation has errors keep
+// the current one.
+func (m *Mix) Reload() error {
+	var errs []error
+	for i, deck := range m.decks {
+		if deck.Automation == "" || deck.Routine != "" {
+			continue
+		}
+		if err := m.scratches[i].Reload(); err != nil {
+			errs = append(errs, fmt.Errorf("deck %d (%s): %w", i+1, deck.Sound, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// SampleRate returns the output sample rate.
+func (m *Mix) SampleRate() uint32 { return m.sampleRate }
+
+// Close closes the files of all decks.
+func (m *Mix) Close() error {
+	var errs []error
+	for _, scr := range m.scratches {
+		errs = append(errs, scr.Close())
+	}
+	if m.beat != nil {
+		errs = append(errs, m.beat.Close())
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,301 @@
+package session_test
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/fruity-loozrz/go-scratchpad/internal/ring"
+	"github.com/fruity-loozrz/go-scratchpad/internal/scratch"
+	"github.com/fruity-loozrz/go-scratchpad/internal/session"
+	"github.com/fruity-loozrz/go-scratchpad/internal/wavout"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseDeck(t *testing.T) {
+	deck, err := session.ParseDeck("sound=beat.wav,gain=0.5,pan=-1")
+	require.NoError(t, err)
+	require.Equal(t, session.Deck{Sound: "beat.wav", Gain: 0.5, Pan: -1}, deck)
+
+	deck, err = session.ParseDeck("automation=a.txt,sound=b.wav")
+	require.NoError(t, err)
+	require.Equal(t, session.NewDeck("b.wav", "a.txt"), deck)
+
+	for _, spec := range []string{"gain=1", "sound=a.wav,gain=loud", "sound=a.wav,speed=2", "a.wav"} {
+		_, err := session.ParseDeck(spec)
+		require.Error(t, err, spec)
+	}
+}
+
+func TestParseDecks(t *testing.T) {
+	decks, err := session.ParseDecks([]string{"a.wav"}, "a.txt", 0.5, []string{"sound=beat.wav"})
+	require.NoError(t, err)
+	require.Equal(t, []session.Deck{
+		{Sound: "a.wav", Automation: "a.txt", Gain: 1, Pan: 0.5},
+		session.NewDeck("beat.wav", ""),
+	}, decks)
+
+	_, err = session.ParseDecks([]string{"a.wav"}, "", 0, nil)
+	require.Error(t, err)
+	_, err = session.ParseDecks(nil, "a.txt", 0, nil)
+	require.Error(t, err)
+	_, err = session.ParseDecks(nil, "", 0, nil)
+	require.ErrorIs(t, err, session.ErrNoDecks)
+}
+
+// writeSound writes a mono WAV file of frames at 100 Hz
+func writeSound(t *testing.T, name string, frames ...float32) string {
+	fileName := filepath.Join(t.TempDir(), name)
+	file, err := os.Create(fileName)
+	require.NoError(t, err)
+	defer file.Close()
+	writer, err := wavout.NewWriter(file, wavout.Format{SampleRate: 100, NumChannels: 1, BitDepth: wavout.BitDepth32Float}, len(frames))
+	require.NoError(t, err)
+	require.NoError(t, writer.WriteFrames(frames))
+	require.NoError(t, writer.Close())
+	return fileName
+}
+
+func TestOpen(t *testing.T) {
+	beat := writeSound(t, "beat.wav", 0.5, 0.5, 0.5, 0.5)
+	mixed, err := session.Open(session.Session{
+		Decks: []session.Deck{
+			session.NewDeck(beat, ""),
+			{Sound: beat, Gain: 0.5, Pan: 1},
+		},
+	})
+	require.NoError(t, err)
+	defer mixed.Close()
+	require.Equal(t, uint32(100), mixed.SampleRate())
+	require.Equal(t, 2, mixed.NumChannels())
+
+	buf, err := io.ReadAll(mixed)
+	require.NoError(t, err)
+	samples := []float64{}
+	for i := 0; i < len(buf); i += 4 {
+		samples = append(samples, float64(math.Float32frombits(binary.LittleEndian.Uint32(buf[i:]))))
+	}
+	// The first deck is centered and the second one hard right, both playing
+	// the sound straight through once
+	center := 0.5 * math.Sqrt2 / 2
+	require.InDeltaSlice(t, []float64{
+		center, center + 0.25,
+		center, center + 0.25,
+		center, center + 0.25,
+		center, center + 0.25,
+		0, 0,
+	}, samples, 1e-6)
+
+	_, err = session.Open(session.Session{Decks: []session.Deck{session.NewDeck(filepath.Join(t.TempDir(), "missing.wav"), "")}})
+	require.Error(t, err)
+	_, err = session.Open(session.Session{})
+	require.ErrorIs(t, err, session.ErrNoDecks)
+}
+
+func TestSectionLoop(t *testing.T) {
+	sound := writeSound(t, "voice.wav", 0, 0.125, 0.25, 0.375, 0.5, 0.625, 0.75, 0.875)
+	automation := filepath.Join(filepath.Dir(sound), "a.auto.txt")
+	require.NoError(t, os.WriteFile(automation, []byte("bpm 6000\ninterpolate linear\n0\n+1\n+1\n+1\n+1"), 0o644))
+
+	render := func(s session.Session) []float64 {
+		s.Decks = []session.Deck{session.NewDeck(sound, automation)}
+		mixed, err := session.Open(s)
+		require.NoError(t, err)
+		defer mixed.Close()
+		buf, err := io.ReadAll(mixed)
+		require.NoError(t, err)
+		samples := []float64{}
+		for i := 0; i < len(buf); i += 4 {
+			samples = append(samples, float64(math.Float32frombits(binary.LittleEndian.Uint32(buf[i:]))))
+		}
+		return samples
+	}
+
+	// Beats 1 to 3 are a frame each at 6000 bpm and 100 Hz
+	full := render(session.Session{})
+	section := full[1:4]
+	require.InDeltaSlice(t, section, render(session.Session{From: 1, To: 3}), 1e-6)
+	require.InDeltaSlice(t, append(append([]float64{}, section...), section...), render(session.Session{From: 1, To: 3, Loop: 2}), 1e-6)
+	require.InDeltaSlice(t, append(append([]float64{}, full...), full...), render(session.Session{Loop: 2}), 1e-6)
+
+	for _, s := range []session.Session{{From: 3, To: 1}, {From: -1}, {From: 10}} {
+		s.Decks = []session.Deck{session.NewDeck(sound, automation)}
+		_, err := session.Open(s)
+		require.Error(t, err)
+	}
+
+	// A section set while playing starts again from its beginning
+	mixed, err := session.Open(session.Session{Decks: []session.Deck{session.NewDeck(sound, automation)}})
+	require.NoError(t, err)
+	defer mixed.Close()
+	_, err = io.ReadFull(mixed, make([]byte, 2*4))
+	require.NoError(t, err)
+	require.NoError(t, mixed.SetSection(1, 3))
+	buf, err := io.ReadAll(mixed)
+	require.NoError(t, err)
+	require.Len(t, buf, len(section)*4)
+	require.InDelta(t, section[0], float64(math.Float32frombits(binary.LittleEndian.Uint32(buf))), 1e-6)
+	for _, bounds := range [][2]float64{{3, 1}, {-1, 0}, {10, 0}} {
+		require.Error(t, mixed.SetSection(bounds[0], bounds[1]), bounds)
+	}
+}
+
+func TestSeek(t *testing.T) {
+	sound := writeSound(t, "voice.wav", 0, 0.125, 0.25, 0.375, 0.5)
+	mixed, err := session.Open(session.Session{Decks: []session.Deck{session.NewDeck(sound, "")}, Loop: 2})
+	require.NoError(t, err)
+	defer mixed.Close()
+
+	buf := make([]byte, 3*4)
+	_, err = io.ReadFull(mixed, buf)
+	require.NoError(t, err)
+	position, err := mixed.Seek(-4, io.SeekCurrent)
+	require.NoError(t, err)
+	require.Equal(t, int64(2*4), position)
+
+	// Seeking plays the mix as many times again
+	buf, err = io.ReadAll(mixed)
+	require.NoError(t, err)
+	samples := []float32{}
+	for i := 0; i < len(buf); i += 4 {
+		samples = append(samples, math.Float32frombits(binary.LittleEndian.Uint32(buf[i:])))
+	}
+	require.Equal(t, []float32{0.25, 0.375, 0.5, 0, 0, 0.125, 0.25, 0.375, 0.5, 0}, samples)
+
+	_, err = mixed.Seek(0, io.SeekEnd)
+	require.ErrorIs(t, err, ring.ErrInvalidSeek)
+	_, err = mixed.Seek(-4, io.SeekStart)
+	require.ErrorIs(t, err, ring.ErrInvalidSeek)
+}
+
+func TestSeekSpeed(t *testing.T) {
+	sound := writeSound(t, "voice.wav", 0, 0.125, 0.25, 0.375, 0.5, 0.625, 0.75, 0.875)
+	mixed, err := session.Open(session.Session{Decks: []session.Deck{session.NewDeck(sound, "")}})
+	require.NoError(t, err)
+	defer mixed.Close()
+
+	// Offsets count the bytes read at the speed the mix plays
+	mixed.SetSpeed(2)
+	buf := make([]byte, 2*4)
+	_, err = io.ReadFull(mixed, buf)
+	require.NoError(t, err)
+	position, err := mixed.Seek(0, io.SeekCurrent)
+	require.NoError(t, err)
+	require.Equal(t, int64(len(buf)), position)
+
+	position, err = mixed.Seek(-4, io.SeekCurrent)
+	require.NoError(t, err)
+	require.Equal(t, int64(4), position)
+	position, err = mixed.Seek(0, io.SeekCurrent)
+	require.NoError(t, err)
+	require.Equal(t, int64(4), position)
+}
+
+func TestClick(t *testing.T) {
+	sound := writeSound(t, "voice.wav", 0.5, 0.5, 0.5, 0.5, 0.5, 0.5, 0.5, 0.5)
+	automation := filepath.Join(filepath.Dir(sound), "a.auto.txt")
+	require.NoError(t, os.WriteFile(automation, []byte("bpm 1200\n0\n+1\n+1"), 0o644))
+
+	render := func(s session.Session) []float32 {
+		mixed, err := session.Open(s)
+		require.NoError(t, err)
+		defer mixed.Close()
+		buf, err := io.ReadAll(mixed)
+		require.NoError(t, err)
+		samples := []float32{}
+		for i := 0; i < len(buf); i += 4 {
+			samples = append(samples, math.Float32frombits(binary.LittleEndian.Uint32(buf[i:])))
+		}
+		return samples
+	}
+
+	decks := []session.Deck{session.NewDeck(sound, automation)}
+	scratched := render(session.Session{Decks: decks})
+	clicked := render(session.Session{Decks: decks, Click: true})
+	stem := render(session.Session{Decks: decks, ClickOnly: true})
+
+	// The stem lines up with the scratch, and both add up to the mix
+	require.Len(t, stem, len(scratched))
+	require.Len(t, clicked, len(scratched))
+	require.NotEqual(t, make([]float32, len(stem)), stem)
+	for i := range stem {
+		require.InDelta(t, scratched[i]+stem[i], clicked[i], 1e-6)
+	}
+
+	_, err := session.Open(session.Session{Decks: []session.Deck{session.NewDeck(sound, "")}, Click: true})
+	require.Error(t, err)
+}
+
+func TestBeat(t *testing.T) {
+	// A loop of two beats at 60 bpm, read from its name
+	frames := make([]float32, 200)
+	for i := range frames {
+		frames[i] = float32(i) / 200
+	}
+	loop := writeSound(t, "break-60bpm.wav", frames...)
+	sound := writeSound(t, "voice.wav", 0)
+	automation := filepath.Join(filepath.Dir(sound), "a.auto.txt")
+
+	render := func(routine string, fit scratch.Fit) []float64 {
+		require.NoError(t, os.WriteFile(automation, []byte(routine), 0o644))
+		beat := session.NewBeat(loop)
+		beat.Fit = fit
+		mixed, err := session.Open(session.Session{
+			Decks: []session.Deck{{Sound: sound, Automation: automation}},
+			Beat:  beat,
+		})
+		require.NoError(t, err)
+		defer mixed.Close()
+		require.Equal(t, 2, mixed.NumChannels())
+		buf, err := io.ReadAll(mixed)
+		require.NoError(t, err)
+		// The left channel of the mono loop, played centered
+		samples := []float64{}
+		for i := 0; i < len(buf); i += 8 {
+			samples = append(samples, float64(math.Float32frombits(binary.LittleEndian.Uint32(buf[i:])))*math.Sqrt2)
+		}
+		return samples
+	}
+
+	// At 120 bpm the loop plays twice as fast, over and over
+	samples := render("bpm 120\n0\n+1\n+1\n+1\n+1", scratch.FitRepitch)
+	require.Len(t, samples, 251)
+	for _, i := range []int{0, 30, 99, 130} {
+		require.InDelta(t, float64(2*i%200)/200, samples[i], 1e-4, i)
+	}
+
+	// At 30 bpm each sixteenth of the loop plays at its own speed on time,
+	// then leaves a gap
+	samples = render("bpm 30\n0\n+1\n+1", scratch.FitStretch)
+	require.Len(t, samples, 601)
+	for k := range 8 {
+		require.InDelta(t, float64(25*k+10)/200, samples[50*k+10], 1e-4, k)
+		require.Zero(t, samples[50*k+40], k)
+	}
+
+	_, err := session.Open(session.Session{
+		Decks: []session.Deck{{Sound: sound, Automation: automation}},
+		Beat:  session.NewBeat(sound),
+	})
+	require.ErrorContains(t, err, "unknown tempo")
+	_, err = session.Open(session.Session{Decks: []session.Deck{session.NewDeck(sound, "")}, Beat: session.NewBeat(loop)})
+	require.Error(t, err)
+}
+
+func TestParseLoop(t *testing.T) {
+	loop, err := session.ParseLoop("3")
+	require.NoError(t, err)
+	require.Equal(t, 3, loop)
+	loop, err = session.ParseLoop("forever")
+	require.NoError(t, err)
+	require.Equal(t, session.LoopForever, loop)
+
+	for _, loop := range []string{"0", "-1", "twice"} {
+		_, err := session.ParseLoop(loop)
+		require.Error(t, err, loop)
+	}
+}
@@ -0,0 +1,296 @@
+// Package wavout writes rendered audio to WAV files.
+package wavout
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand/v2"
+)
+
+// BitDepth is the sample format of a WAV file.
+type BitDepth string
+
+const (
+	BitDepth16      BitDepth = "16"
+	BitDepth24      BitDepth = "24"
+	BitDepth32Float BitDepth = "32f"
+
+	DefaultBitDepth = BitDepth16
+)
+
+const (
+	formatPCM        = 1
+	formatIEEEFloat  = 3
+	formatExtensible = 0xfffe
+)
+
+// subFormatSuffix ends the sub-format GUID of the extensible format, after
+// the format code.
+var subFormatSuffix = []byte{0x00, 0x00, 0x00, 0x00, 0x10, 0x00, 0x80, 0x00, 0x00, 0xaa, 0x00, 0x38, 0x9b, 0x71}
+
+// unknownFrames is the length of a streamed file in its header.
+const unknownFrames = -1
+
+var ErrFrameSize = errors.New("samples do not make whole frames")
+
+// ParseBitDepth parses a bit depth as used on the command line.
+func ParseBitDepth(s string) (BitDepth, error) {
+	switch d := BitDepth(s); d {
+	case BitDepth16, BitDepth24, BitDepth32Float:
+		return d, nil
+	}
+	return "", fmt.Errorf("invalid bit depth %q (expected %q, %q or %q)",
+		s, BitDepth16, BitDepth24, BitDepth32Float)
+}
+
+// bytes returns the size of a sample in bytes.
+func (d BitDepth) bytes() int {
+	switch d {
+	case BitDepth24:
+		return 3
+	case BitDepth32Float:
+		return 4
+	default:
+		return 2
+	}
+}
+
+// Format describes the samples of a WAV file.
+type Format struct {
+	SampleRate  uint32
+	NumChannels int
+	BitDepth    BitDepth
+}
+
+func (f Format) blockAlign() int {
+	return f.NumChannels * f.BitDepth.bytes()
+}
+
+// Writer writes interleaved float samples to a WAV file, converting them to
+// the bit depth of the file.
+type Writer struct {
+	w      io.Writer
+	format Format
+	dither *rand.Rand
+	buf    []byte
+	// written is the size of the samples written so far.
+	written int64
+	// streaming is set when the length was not known up front, so that the
+	// header must be patched on close.
+	streaming bool
+}
+
+// NewWriter writes the header of a WAV file holding numFrames frames and
+// returns a writer for its samples.
+func NewWriter(w io.Writer, format Format, numFrames int) (*Writer, error) {
+	writer, err := newWriter(w, format)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(writer.header(numFrames)); err != nil {
+		return nil, err
+	}
+	return writer, nil
+}
+
+// NewStreamWriter writes the header of a WAV file of unknown length and
+// returns a writer for its samples. The header claims the largest sizes RIFF
+// allows, which streaming readers take as "until the end of the stream";
+// Close fixes them when w can seek back to the header.
+func NewStreamWriter(w io.Writer, format Format) (*Writer, error) {
+	writer, err := newWriter(w, format)
+	if err != nil {
+		return nil, err
+	}
+	writer.streaming = true
+	if _, err := w.Write(writer.header(unknownFrames)); err != nil {
+		return nil, err
+	}
+	return writer, nil
+}
+
+func newWriter(w io.Writer, format Format) (*Writer, error) {
+	if format.NumChannels <= 0 || format.SampleRate == 0 {
+		return nil, fmt.Errorf("invalid format: %d channels at %d Hz", format.NumChannels, format.SampleRate)
+	}
+	if _, err := ParseBitDepth(string(format.BitDepth)); err != nil {
+		return nil, err
+	}
+	return &Writer{w: w, format: format}, nil
+}
+
+// SetDither turns TPDF dither on or off. Dither trades the distortion of
+// truncating quiet signals to 16 or 24 bits for a constant low noise floor;
+// float output is never dithered.
+func (w *Writer) SetDither(on bool) {
+	w.dither = nil
+	if on {
+		// A fixed seed keeps renders reproducible
+		w.dither = rand.New(rand.NewPCG(0x5c2a7c4, 0xd17e2))
+	}
+}
+
+func (w *Writer) header(numFrames int) []byte {
+	format := formatPCM
+	if w.format.BitDepth == BitDepth32Float {
+		format = formatIEEEFloat
+	}
+	bits := uint16(8 * w.format.BitDepth.bytes())
+	blockAlign := w.format.blockAlign()
+
+	fmtChunk := binary.LittleEndian.AppendUint16(nil, uint16(format))
+	// Files with more than two channels need the extensible format to tell
+	// the speaker layout
+	extensible := w.format.NumChannels > 2
+	if extensible {
+		fmtChunk = binary.LittleEndian.AppendUint16(nil, formatExtensible)
+	}
+	fmtChunk = binary.LittleEndian.AppendUint16(fmtChunk, uint16(w.format.NumChannels))
+	fmtChunk = binary.LittleEndian.AppendUint32(fmtChunk, w.format.SampleRate)
+	fmtChunk = binary.LittleEndian.AppendUint32(fmtChunk, w.format.SampleRate*uint32(blockAlign))
+	fmtChunk = binary.LittleEndian.AppendUint16(fmtChunk, uint16(blockAlign))
+	fmtChunk = binary.LittleEndian.AppendUint16(fmtChunk, bits)
+	switch {
+	case extensible:
+		fmtChunk = binary.LittleEndian.AppendUint16(fmtChunk, 22)
+		fmtChunk = binary.LittleEndian.AppendUint16(fmtChunk, bits)
+		fmtChunk = binary.LittleEndian.AppendUint32(fmtChunk, channelMask(w.format.NumChannels))
+		fmtChunk = binary.LittleEndian.AppendUint16(fmtChunk, uint16(format))
+		fmtChunk = append(fmtChunk, subFormatSuffix...)
+	case format != formatPCM:
+		fmtChunk = binary.LittleEndian.AppendUint16(fmtChunk, 0)
+	}
+
+	dataSize := uint32(numFrames * blockAlign)
+	size := uint32(4 + 8 + len(fmtChunk) + 8 + numFrames*blockAlign + numFrames*blockAlign%2)
+	if format != formatPCM {
+		// Non-PCM files need a fact chunk with the number of frames
+		size += 8 + 4
+	}
+	if numFrames == unknownFrames {
+		size, dataSize = math.MaxUint32, math.MaxUint32
+	}
+
+	h := []byte("RIFF")
+	h = binary.LittleEndian.AppendUint32(h, size)
+	h = append(h, "WAVEfmt "...)
+	h = binary.LittleEndian.AppendUint32(h, uint32(len(fmtChunk)))
+	h = append(h, fmtChunk...)
+	if format != formatPCM {
+		h = append(h, "fact"...)
+		h = binary.LittleEndian.AppendUint32(h, 4)
+		h = binary.LittleEndian.AppendUint32(h, uint32(max(numFrames, 0)))
+	}
+	h = append(h, "data"...)
+	h = binary.LittleEndian.AppendUint32(h, dataSize)
+	return h
+}
+
+// channelMask returns the usual speaker layout for a number of channels, or
+// no layout when there is none.
+func channelMask(numChannels int) uint32 {
+	const (
+		frontLeft = 1 << iota
+		frontRight
+		frontCenter
+		lowFrequency
+		backLeft
+		backRight
+		_
+		_
+		backCenter
+		sideLeft
+		sideRight
+	)
+
+	switch numChannels {
+	case 3:
+		return frontLeft | frontRight | frontCenter
+	case 4:
+		return frontLeft | frontRight | backLeft | backRight
+	case 5:
+		return frontLeft | frontRight | frontCenter | backLeft | backRight
+	case 6:
+		return frontLeft | frontRight | frontCenter | lowFrequency | backLeft | backRight
+	case 7:
+		return frontLeft | frontRight | frontCenter | lowFrequency | backCenter | sideLeft | sideRight
+	case 8:
+		return frontLeft | frontRight | frontCenter | lowFrequency | backLeft | backRight | sideLeft | sideRight
+	}
+	return 0
+}
+
+// WriteFrames writes interleaved samples, between -1 and 1, holding whole
+// frames.
+func (w *Writer) WriteFrames(samples []float32) error {
+	if len(samples)%w.format.NumChannels != 0 {
+		return ErrFrameSize
+	}
+
+	w.buf = w.buf[:0]
+	for _, sample := range samples {
+		switch w.format.BitDepth {
+		case BitDepth32Float:
+			w.buf = binary.LittleEndian.AppendUint32(w.buf, math.Float32bits(sample))
+		case BitDepth24:
+			v := w.quantize(sample, 24)
+			w.buf = append(w.buf, byte(v), byte(v>>8), byte(v>>16))
+		default:
+			w.buf = binary.LittleEndian.AppendUint16(w.buf, uint16(w.quantize(sample, 16)))
+		}
+	}
+
+	n, err := w.w.Write(w.buf)
+	w.written += int64(n)
+	return err
+}
+
+// Close pads the data chunk to an even size, as RIFF requires, and rewrites
+// the header of a streamed file with its final sizes if the underlying writer
+// can seek. It does not close the underlying writer.
+func (w *Writer) Close() error {
+	if w.written%2 != 0 {
+		if _, err := w.w.Write([]byte{0}); err != nil {
+			return err
+		}
+	}
+	if !w.streaming {
+		return nil
+	}
+
+	seeker, ok := w.w.(io.WriteSeeker)
+	if !ok {
+		return nil
+	}
+	end, err := seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		// Pipes are files that cannot seek: leave the streaming header
+		return nil
+	}
+	header := w.header(int(w.written / int64(w.format.blockAlign())))
+	start := end - int64(len(header)) - w.written - w.written%2
+	if _, err := seeker.Seek(start, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := seeker.Write(header); err != nil {
+		return err
+	}
+	_, err = seeker.Seek(end, io.SeekStart)
+	return err
+}
+
+// quantize converts a sample to a signed integer of the given number of bits,
+// rounding it, dithering it if asked and clipping it.
+func (w *Writer) quantize(sample float32, bits int) int32 {
+	scale := float64(int64(1) << (bits - 1))
+	v := float64(sample) * scale
+	if w.dither != nil {
+		// Triangular noise of one LSB peak
+		v += w.dither.Float64() - w.dither.Float64()
+	}
+	v = math.Round(v)
+	return int32(min(max(v, -scale), scale-1))
+}
@@ -0,0 +1,139 @@
+package wavout_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/fruity-loozrz/go-scratchpad/internal/ring"
+	"github.com/fruity-loozrz/go-scratchpad/internal/wavout"
+
+	"github.com/stretchr/testify/require"
+)
+
+func write(t *testing.T, format wavout.Format, dither bool, samples []float32) []byte {
+	var buf bytes.Buffer
+	writer, err := wavout.NewWriter(&buf, format, len(samples)/format.NumChannels)
+	require.NoError(t, err)
+	writer.SetDither(dither)
+	require.NoError(t, writer.WriteFrames(samples))
+	require.NoError(t, writer.Close())
+	return buf.Bytes()
+}
+
+func TestWriter(t *testing.T) {
+	samples := []float32{-1, 0.5, 0, -0.25, 0.125, 1.5}
+
+	for bitDepth, expected := range map[wavout.BitDepth][]float64{
+		wavout.BitDepth16:      {-1, 0.5, 0, -0.25, 0.125, 32767.0 / 32768},
+		wavout.BitDepth24:      {-1, 0.5, 0, -0.25, 0.125, 8388607.0 / 8388608},
+		wavout.BitDepth32Float: {-1, 0.5, 0, -0.25, 0.125, 1.5},
+	} {
+		t.Run(string(bitDepth), func(t *testing.T) {
+			format := wavout.Format{SampleRate: 48000, NumChannels: 3, BitDepth: bitDepth}
+			file := write(t, format, false, samples)
+			require.Zero(t, len(file)%2)
+
+			store, err := ring.NewWavStore(bytes.NewReader(file))
+			require.NoError(t, err)
+			require.Equal(t, uint32(48000), store.SampleRate())
+			require.Equal(t, 3, store.NumChannels())
+			require.Equal(t, 2, store.Frames())
+			actual := []float64{}
+			for i := range expected {
+				actual = append(actual, store.Sample(i/3, i%3))
+			}
+			require.Equal(t, expected, actual)
+		})
+	}
+}
+
+func TestWriterExtensible(t *testing.T) {
+	samples := make([]float32, 6*10)
+	for i := range samples {
+		samples[i] = float32(i%6) / 8
+	}
+
+	for _, bitDepth := range []wavout.BitDepth{wavout.BitDepth16, wavout.BitDepth32Float} {
+		format := wavout.Format{SampleRate: 44100, NumChannels: 6, BitDepth: bitDepth}
+		file := write(t, format, false, samples)
+		require.Equal(t, uint16(0xfffe), binary.LittleEndian.Uint16(file[20:]))
+		// The 5.1 speaker layout
+		require.Equal(t, uint32(0x3f), binary.LittleEndian.Uint32(file[40:]))
+
+		store, err := ring.NewWavStore(bytes.NewReader(file))
+		require.NoError(t, err)
+		require.Equal(t, 6, store.NumChannels())
+		require.Equal(t, 10, store.Frames())
+		for ch := range 6 {
+			require.Equal(t, float64(ch)/8, store.Sample(9, ch))
+		}
+	}
+}
+
+func TestStreamWriter(t *testing.T) {
+	samples := []float32{-1, 0.5, 0, -0.25, 0.125}
+	for _, bitDepth := range []wavout.BitDepth{wavout.BitDepth16, wavout.BitDepth24, wavout.BitDepth32Float} {
+		format := wavout.Format{SampleRate: 44100, NumChannels: 1, BitDepth: bitDepth}
+		expected := write(t, format, false, samples)
+
+		// A file gets the same header as when the length is known
+		file, err := os.Create(filepath.Join(t.TempDir(), "out.wav"))
+		require.NoError(t, err)
+		defer file.Close()
+		writer, err := wavout.NewStreamWriter(file, format)
+		require.NoError(t, err)
+		require.NoError(t, writer.WriteFrames(samples[:2]))
+		require.NoError(t, writer.WriteFrames(samples[2:]))
+		require.NoError(t, writer.Close())
+		actual, err := os.ReadFile(file.Name())
+		require.NoError(t, err)
+		require.Equal(t, expected, actual)
+
+		// A stream keeps the open-ended header and still reads back
+		var buf bytes.Buffer
+		writer, err = wavout.NewStreamWriter(&buf, format)
+		require.NoError(t, err)
+		require.NoError(t, writer.WriteFrames(samples))
+		require.NoError(t, writer.Close())
+		require.Equal(t, uint32(0xffffffff), binary.LittleEndian.Uint32(buf.Bytes()[4:]))
+		store, err := ring.NewWavStore(bytes.NewReader(buf.Bytes()))
+		require.NoError(t, err)
+		require.Equal(t, len(samples), store.Frames())
+	}
+}
+
+func TestWriterDither(t *testing.T) {
+	// A signal below one LSB is lost without dither
+	samples := make([]float32, 4096)
+	for i := range samples {
+		samples[i] = 0.4 / 32768
+	}
+	format := wavout.Format{SampleRate: 44100, NumChannels: 1, BitDepth: wavout.BitDepth16}
+
+	mean := func(file []byte) float64 {
+		store, err := ring.NewWavStore(bytes.NewReader(file))
+		require.NoError(t, err)
+		sum := 0.0
+		for i := range store.Frames() {
+			sum += store.Sample(i, 0)
+		}
+		return sum / float64(store.Frames()) * 32768
+	}
+
+	require.Zero(t, mean(write(t, format, false, samples)))
+	require.InDelta(t, 0.4, mean(write(t, format, true, samples)), 0.05)
+	require.Equal(t, write(t, format, true, samples), write(t, format, true, samples))
+}
+
+func TestWriterErrors(t *testing.T) {
+	_, err := wavout.ParseBitDepth("8")
+	require.Error(t, err)
+
+	var buf bytes.Buffer
+	writer, err := wavout.NewWriter(&buf, wavout.Format{SampleRate: 44100, NumChannels: 2, BitDepth: wavout.BitDepth16}, 1)
+	require.NoError(t, err)
+	require.ErrorIs(t, writer.WriteFrames([]float32{0, 0, 0}), wavout.ErrFrameSize)
+}